@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+const (
+	AccessRead    = "read"
+	AccessCompare = "compare"
+	AccessWrite   = "write"
+)
+
+// ACL grants access to entries and attributes, everything not granted by a rule is denied.
+type ACL struct {
+	Rules []aclRule `json:"rules"`
+}
+
+// aclRule grants access to the attributes of entries matching dn or below subtree.
+// who lists "*" for everyone, "anonymous", "users" for all bound users, "services" for all bound service accounts,
+// "self" for the user's own entry,
+// "dn:<bind dn>" and "group:<name or dn>" for direct and nested members of a group.
+// A rule without attrs covers whole entries and is required to add, delete or rename entries.
+type aclRule struct {
+	Who     []string `json:"who"`
+	Dn      string   `json:"dn"`
+	Subtree string   `json:"subtree"`
+	Attrs   []string `json:"attrs"`
+	Access  []string `json:"access"`
+}
+
+// aclSubject is the bound user or service account an access is checked for, anonymous with an empty name.
+type aclSubject struct {
+	name    string
+	dn      string
+	groups  []string
+	service bool
+}
+
+func LoadACL(file string) (*ACL, error) {
+	var acl ACL
+	if content, err := ioutil.ReadFile(file); err != nil {
+		return nil, err
+	} else if err := json.Unmarshal(content, &acl); err != nil {
+		return nil, err
+	}
+
+	for i, rule := range acl.Rules {
+		for _, who := range rule.Who {
+			if who != "*" && who != "anonymous" && who != "users" && who != "services" && who != "self" &&
+				!strings.HasPrefix(who, "dn:") && !strings.HasPrefix(who, "group:") {
+				return nil, fmt.Errorf("acl rule #%d: unknown who %q", i+1, who)
+			}
+		}
+		for _, access := range rule.Access {
+			if access != AccessRead && access != AccessCompare && access != AccessWrite {
+				return nil, fmt.Errorf("acl rule #%d: unknown access %q", i+1, access)
+			}
+		}
+		for _, dn := range []string{rule.Dn, rule.Subtree} {
+			if _, err := ParseDN(dn); err != nil {
+				return nil, fmt.Errorf("acl rule #%d: %s", i+1, err.Error())
+			}
+		}
+	}
+	return &acl, nil
+}
+
+// allowed reports whether any rule grants the access to the attribute of the entry.
+// An empty attr asks for access to the whole entry.
+func (a *ACL) allowed(subject aclSubject, dn, attr, access string, c *Config) bool {
+	for _, rule := range a.Rules {
+		if contains(rule.Access, access) && rule.coversEntry(dn) && rule.coversAttr(attr) && rule.matches(subject, dn, c) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *aclRule) coversEntry(dn string) bool {
+	if r.Dn != "" && !equalDns(r.Dn, dn) {
+		return false
+	} else if r.Subtree != "" && !equalDns(r.Subtree, dn) {
+		subtree, _ := ParseDN(r.Subtree)
+		entry, err := ParseDN(dn)
+		return err == nil && entry.IsDescendantOf(subtree)
+	}
+	return true
+}
+
+func (r *aclRule) coversAttr(attr string) bool {
+	if len(r.Attrs) == 0 || contains(r.Attrs, "*") {
+		return true
+	}
+	return attr != "" && containsFold(r.Attrs, attr)
+}
+
+func (r *aclRule) matches(subject aclSubject, dn string, c *Config) bool {
+	for _, who := range r.Who {
+		switch {
+		case who == "*":
+			return true
+		case who == "anonymous" && subject.name == "":
+			return true
+		case who == "users" && subject.name != "" && !subject.service:
+			return true
+		case who == "services" && subject.service:
+			return true
+		case who == "self" && subject.name != "" && equalDns(subject.dn, dn):
+			return true
+		case strings.HasPrefix(who, "dn:") && subject.name != "" && equalDns(strings.TrimPrefix(who, "dn:"), subject.dn):
+			return true
+		case strings.HasPrefix(who, "group:") && subject.name != "":
+			group := strings.TrimPrefix(who, "group:")
+			for _, g := range subject.groups {
+				if strings.EqualFold(g, group) || equalDns(c.groupDn(g), group) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
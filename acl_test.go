@@ -0,0 +1,133 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/mark-rushakoff/ldapserver"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testACL = `{
+	"rules": [
+		{"who": ["users"], "subtree": "ou=people,ou=test,dc=example,dc=com", "attrs": ["cn", "mail", "objectClass"], "access": ["read", "compare"]},
+		{"who": ["self"], "attrs": ["*"], "access": ["read"]},
+		{"who": ["self"], "attrs": ["mail"], "access": ["write"]},
+		{"who": ["group:g3"], "subtree": "ou=groups,ou=test,dc=example,dc=com", "access": ["read", "compare"]},
+		{"who": ["group:cn=admins,ou=groups,ou=test,dc=example,dc=com", "dn:cn=root,ou=people,ou=test,dc=example,dc=com"], "access": ["read", "compare", "write"]},
+		{"who": ["anonymous"], "dn": "cn=u2,ou=people,ou=test,dc=example,dc=com", "attrs": ["cn"], "access": ["read"]},
+		{"who": ["services"], "subtree": "ou=groups,ou=test,dc=example,dc=com", "attrs": ["cn", "member"], "access": ["read"]}
+	]
+}`
+)
+
+func loadTestACL(t *testing.T, content string) (*ACL, error) {
+	f, _ := ioutil.TempFile(os.TempDir(), "aldapd-acl")
+	defer os.Remove(f.Name())
+	f.WriteString(content)
+	return LoadACL(f.Name())
+}
+
+func TestLoadACL(t *testing.T) {
+	acl, err := loadTestACL(t, testACL)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, len(acl.Rules))
+
+	for _, content := range []string{
+		`not json`,
+		`{"rules": [{"who": ["everybody"], "access": ["read"]}]}`,
+		`{"rules": [{"who": ["*"], "access": ["delete"]}]}`,
+		`{"rules": [{"who": ["*"], "subtree": "invalid", "access": ["read"]}]}`,
+	} {
+		_, err := loadTestACL(t, content)
+		assert.Error(t, err, "for %s", content)
+	}
+}
+
+func TestACL_allowed(t *testing.T) {
+	acl, _ := loadTestACL(t, testACL)
+	c := newTestConfig()
+	u1 := aclSubject{name: "u1", dn: c.userDn("u1"), groups: []string{"g1", "g3"}}
+	u2 := aclSubject{name: "u2", dn: c.userDn("u2"), groups: []string{"g1"}}
+	admin := aclSubject{name: "a", dn: c.userDn("a"), groups: []string{"admins"}}
+	root := aclSubject{name: "root", dn: c.userDn("root")}
+	anonymous := aclSubject{}
+	service := aclSubject{name: "nextcloud", dn: c.serviceDn("nextcloud"), service: true}
+
+	cases := []struct {
+		subject          aclSubject
+		dn, attr, access string
+		expected         bool
+	}{
+		{u1, c.userDn("u2"), "mail", AccessRead, true},
+		{u1, c.userDn("u2"), "MAIL", AccessCompare, true},
+		{u1, c.userDn("u2"), "telephoneNumber", AccessRead, false},
+		{u1, c.userDn("u1"), "telephoneNumber", AccessRead, true},
+		{u1, c.userDn("u1"), "mail", AccessWrite, true},
+		{u1, c.userDn("u1"), "cn", AccessWrite, false},
+		{u1, c.userDn("u1"), "", AccessWrite, false},
+		{u1, c.userDn("u2"), "mail", AccessWrite, false},
+		{u1, c.groupDn("g1"), "member", AccessRead, true},
+		{u2, c.groupDn("g1"), "member", AccessRead, false},
+		{admin, c.groupDn("g1"), "", AccessWrite, true},
+		{root, c.userDn("u1"), "userPassword", AccessWrite, true},
+		{anonymous, c.userDn("u2"), "cn", AccessRead, true},
+		{anonymous, c.userDn("u2"), "mail", AccessRead, false},
+		{anonymous, c.userDn("u1"), "cn", AccessRead, false},
+		{service, c.groupDn("g1"), "member", AccessRead, true},
+		{service, c.userDn("u1"), "cn", AccessRead, false},
+		{u2, c.groupDn("g1"), "cn", AccessRead, false},
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.expected, acl.allowed(tc.subject, tc.dn, tc.attr, tc.access, c), "for %s on %s %s of %s", tc.subject.name, tc.access, tc.attr, tc.dn)
+	}
+}
+
+func TestServer_acl(t *testing.T) {
+	acl, _ := loadTestACL(t, testACL)
+	c := newTestConfig()
+	c.acl = acl
+	tb := newTestNameBackend()
+	usersFunc := tb.usersFunc
+	tb.usersFunc = func(filterKey, filterValue string) ([]User, error) {
+		if filterKey == "" || filterKey == "telephoneNumber" {
+			return []User{newTestUser("u1"), newTestUser("u2")}, nil
+		}
+		return usersFunc(filterKey, filterValue)
+	}
+	c.backend = tb
+	s := NewServer(c)
+	s.sessions.bind(nil, "u1")
+
+	result, err := s.search("", ldapserver.SearchRequest{BaseDN: c.peopleDn, Filter: "(objectClass=*)"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(result.Entries))
+	for _, entry := range result.Entries {
+		assert.Equal(t, 1, len(entry.GetAttributeValues("mail")))
+		assert.Equal(t, entry.DN == c.userDn("u1"), len(entry.GetAttributeValues("memberOf")) > 0)
+	}
+
+	result, err = s.search("", ldapserver.SearchRequest{BaseDN: c.peopleDn, Filter: "(telephoneNumber=123)"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(result.Entries))
+	assert.Equal(t, c.userDn("u1"), result.Entries[0].DN)
+
+	code, _ := s.compare("", ldapserver.CompareRequest{DN: c.userDn("u2"), Name: "mail", Value: "u2@example.org"}, nil)
+	assert.Equal(t, ldapserver.LDAPResultCompareTrue, code)
+	code, _ = s.compare("", ldapserver.CompareRequest{DN: c.userDn("u2"), Name: "memberOf", Value: c.groupDn("g1")}, nil)
+	assert.Equal(t, ldapserver.LDAPResultInsufficientAccessRights, code)
+
+	s.sessions.forget(nil)
+	result, _ = s.search("", ldapserver.SearchRequest{BaseDN: c.peopleDn, Filter: "(objectClass=*)"}, nil)
+	assert.Equal(t, 1, len(result.Entries))
+	assert.Equal(t, 1, len(result.Entries[0].Attributes))
+}
+
+func TestUser2entry_userPassword(t *testing.T) {
+	u := newTestUser("u1")
+	u.Attr["userPassword"] = []string{"{SSHA}secret"}
+	entry := user2entry(&u, newTestConfig())
+	assert.Equal(t, []string{}, entry.GetAttributeValues("userPassword"))
+}
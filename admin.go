@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// adminHandler serves the admin interface: GET /lockouts lists locked out users,
+// DELETE /lockouts/<key> lifts a lockout.
+func (s *Server) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/lockouts", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		locked := make([]LockedOut, 0)
+		if s.config.lockout != nil {
+			locked = s.config.lockout.lockedOut()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(locked)
+	})
+	mux.HandleFunc("/lockouts/", func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/lockouts/")
+		if r.Method != http.MethodDelete {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		} else if s.config.lockout == nil || !s.config.lockout.unlock(key) {
+			http.NotFound(w, r)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+	return mux
+}
+
+// ListenAndServeAdmin serves the admin interface. It has no authentication,
+// so it refuses to listen on other than loopback addresses unless insecure is set.
+func (s *Server) ListenAndServeAdmin(addr string, insecure bool) error {
+	ln, err := adminListener(addr, insecure)
+	if err != nil {
+		return err
+	}
+	log.Infof("starting admin interface on %s", ln.Addr())
+	return http.Serve(ln, s.adminHandler())
+}
+
+func adminListener(addr string, insecure bool) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if tcpAddr, ok := ln.Addr().(*net.TCPAddr); !insecure && (!ok || !tcpAddr.IP.IsLoopback()) {
+		ln.Close()
+		return nil, fmt.Errorf("refusing to serve the unauthenticated admin interface on %s, use a loopback address or --admin-insecure", ln.Addr())
+	}
+	return ln, nil
+}
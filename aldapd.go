@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/jessevdk/go-flags"
 	"github.com/op/go-logging"
@@ -18,24 +19,198 @@ var opts struct {
 	Verbose []bool `short:"v" long:"verbose" description:"Show more verbose logs"`
 	Silent  bool   `short:"s" long:"silent" description:"Show critical messages only"`
 
-	ListenAddr    string `short:"a" long:"address" default:"localhost" description:"Listen on this address"`
-	ListenPort    uint32 `short:"p" long:"port" default:"389" description:"Listen on this port"`
-	BaseDn        string `short:"b" long:"base-dn" default:"dc=felixb,dc=github,dc=com" description:"Present users and groups under this FDN"`
-	AllowAnonBind bool   `long:"allow-anon-bind" description:"Allow bind with empty bind DN and password"`
+	ListenAddr string `short:"a" long:"address" default:"localhost" description:"Listen on this address"`
+	ListenPort uint32 `short:"p" long:"port" default:"389" description:"Listen on this port"`
 
-	Files []string `short:"f" long:"file" required:"true" description:"Config file with user/group data"`
+	MaxConns      int           `long:"max-conns" description:"Accept at most this many concurrent connections, 0 is unlimited"`
+	MaxConnsPerIP int           `long:"max-conns-per-ip" description:"Accept at most this many concurrent connections per source IP, 0 is unlimited"`
+	IdleTimeout   time.Duration `long:"idle-timeout" description:"Close connections without requests for this duration, e.g. 5m"`
+	BindRate      float64       `long:"bind-rate" description:"Allow this many binds per second and source IP, 0 is unlimited"`
+	SearchRate    float64       `long:"search-rate" description:"Allow this many searches per second and source IP, 0 is unlimited"`
+	RateBurst     int           `long:"rate-burst" default:"10" description:"Allow bursts of this many binds or searches above --bind-rate and --search-rate"`
+
+	BaseDn        string   `short:"b" long:"base-dn" default:"dc=felixb,dc=github,dc=com" description:"Present users and groups under this FDN"`
+	AllowAnonBind bool     `long:"allow-anon-bind" description:"Allow bind with empty bind DN and password"`
+	PeopleOu      string   `long:"people-ou" default:"people" description:"Present users under ou=<people-ou>,<base-dn>"`
+	GroupsOu      string   `long:"groups-ou" default:"groups" description:"Present groups under ou=<groups-ou>,<base-dn>"`
+	ServicesOu    string   `long:"services-ou" default:"services" description:"Present service accounts under ou=<services-ou>,<base-dn>"`
+	UserRdnAttr   string   `long:"user-rdn-attr" default:"cn" description:"Naming attribute of users' DNs, e.g. uid"`
+	GroupRdnAttr  string   `long:"group-rdn-attr" default:"cn" description:"Naming attribute of groups' DNs"`
+	BindAttrs     []string `long:"bind-attr" description:"Allow binding with this attribute as DN or plain identifier, e.g. mail or userPrincipalName"`
+
+	Files               []string `short:"f" long:"file" description:"Config file with user/group data"`
+	LocalFiles          []string `short:"l" long:"local-file" description:"Host local config file with user/group data, takes priority over --file"`
+	HtpasswdFiles       []string `long:"htpasswd-file" description:"Apache htpasswd file with users and passwords, lowest priority"`
+	GroupFiles          []string `long:"group-file" description:"File in /etc/group format with group memberships for --htpasswd-file"`
+	PublicKey           string   `long:"public-key" description:"Reject --file snapshots without a valid minisign signature by this public key"`
+	SnapshotKey         string   `long:"snapshot-key" description:"Decrypt encrypted --file snapshots with the key from this file"`
+	AllowDowngrade      bool     `long:"allow-downgrade" description:"Load --file snapshots with a version older than the current one"`
+	SnapshotHistory     string   `long:"snapshot-history" description:"Keep the last loaded --file snapshots in this directory, send SIGUSR2 to roll back"`
+	SnapshotHistorySize int      `long:"snapshot-history-size" default:"5" description:"Number of snapshots kept in --snapshot-history"`
+	Schema              string   `long:"schema" default:"none" choice:"none" choice:"rfc2307" choice:"rfc2307bis" description:"Add posixAccount and posixGroup attributes"`
+	HomeDirectory       string   `long:"home-directory" default:"/home/{name}" description:"Template for homeDirectory of posix accounts"`
+	LoginShell          string   `long:"login-shell" default:"/bin/sh" description:"Default loginShell of posix accounts"`
+	DefaultGid          string   `long:"default-gid" description:"Default gidNumber of posix accounts, without it users need an explicit gidNumber"`
+	IdAllocation        string   `long:"id-allocation" default:"none" choice:"none" choice:"hash" choice:"file" description:"Allocate uidNumber/gidNumber for users/groups without them"`
+	IdAllocationFile    string   `long:"id-allocation-file" description:"Persist allocated ids in this file, for --id-allocation=file"`
+	UidRange            string   `long:"uid-range" default:"10000-59999" description:"Allocate uidNumbers in this range"`
+	GidRange            string   `long:"gid-range" default:"10000-59999" description:"Allocate gidNumbers in this range"`
+	Templates           string   `long:"templates" description:"JSON file with attribute templates for users and groups"`
+	Precedence          string   `long:"precedence" default:"first" choice:"first" choice:"last" choice:"merge" description:"Resolve name collisions between users/groups of different sources"`
+	PasswordJournal     string   `long:"password-journal" description:"Allow password changes and record them in this file, they override older snapshots"`
+	PasswordEndpoint    string   `long:"password-endpoint" description:"Allow password changes and forward them as JSON via POST to this URL"`
+	Writable            bool     `long:"writable" description:"Allow adding, modifying and deleting users and groups of the single --file snapshot"`
+	Writers             []string `long:"writer" description:"Allow this bind DN to write with --writable"`
+	ACL                 string   `long:"acl" description:"JSON file with access control rules, everything not granted is denied"`
+	InactiveAccounts    string   `long:"inactive-accounts" default:"show" choice:"show" choice:"mark" choice:"hide" description:"Show, mark with nsAccountLock and pwdAccountLockedTime or hide disabled and expired users"`
+
+	LockoutThreshold int           `long:"lockout-threshold" description:"Lock out users after this many failed binds, 0 disables lockouts"`
+	LockoutDuration  time.Duration `long:"lockout-duration" default:"15m" description:"Duration of lockouts, failed binds further apart are not counted"`
+	LockoutPerIP     bool          `long:"lockout-per-ip" description:"Count failed binds and lock out per user and source IP"`
+	AdminAddress     string        `long:"admin-address" description:"Serve the unauthenticated admin interface on this address, e.g. localhost:8389"`
+	AdminInsecure    bool          `long:"admin-insecure" description:"Allow serving the admin interface on other than loopback addresses"`
+
+	PasswordMaxAge        time.Duration `long:"password-max-age" description:"Let passwords expire this long after passwordChangedAt, e.g. 2160h"`
+	PasswordExpireWarning time.Duration `long:"password-expire-warning" default:"168h" description:"Warn about expiring passwords this long before"`
+	PasswordGraceLogins   int           `long:"password-grace-logins" description:"Allow this many binds with an expired password"`
+	PasswordGraceFile     string        `long:"password-grace-file" description:"Keep used grace logins in this file across restarts"`
 }
 
-func main() {
-	if _, err := flags.Parse(&opts); err != nil {
-		os.Exit(1)
+// newProcessors creates the configured data processors, with readOnly they don't write any state.
+func newProcessors(readOnly bool) ([]DataProcessor, error) {
+	processors := make([]DataProcessor, 0)
+	if opts.IdAllocation != IdAllocationNone {
+		if uidRange, err := ParseIdRange(opts.UidRange); err != nil {
+			return nil, err
+		} else if gidRange, err := ParseIdRange(opts.GidRange); err != nil {
+			return nil, err
+		} else if allocator, err := NewIdAllocator(opts.IdAllocation, uidRange, gidRange, opts.IdAllocationFile); err != nil {
+			return nil, err
+		} else {
+			allocator.readOnly = readOnly
+			processors = append(processors, allocator)
+		}
 	}
+	return processors, nil
+}
 
-	if opts.Version {
-		fmt.Printf("aldapd version: %s\n", VERSION)
-		os.Exit(0)
+func newSnapshotConfig(processors []DataProcessor, templates *AttrTemplates, journal *passwordJournal, readOnly bool) (*SnapshotConfig, error) {
+	snapshot := &SnapshotConfig{
+		allowDowngrade: opts.AllowDowngrade,
+		historyDir:     opts.SnapshotHistory,
+		historySize:    opts.SnapshotHistorySize,
+		processors:     processors,
+		templates:      templates,
+		journal:        journal,
+		writable:       opts.Writable,
+	}
+	if readOnly {
+		// don't add checked snapshots to the history
+		snapshot.historyDir = ""
+	}
+	if opts.PublicKey != "" {
+		if key, err := LoadMinisignPublicKey(opts.PublicKey); err != nil {
+			return nil, err
+		} else {
+			snapshot.publicKey = key
+		}
+	}
+	if opts.SnapshotKey != "" {
+		if key, err := LoadSnapshotKey(opts.SnapshotKey); err != nil {
+			return nil, err
+		} else {
+			snapshot.key = key
+		}
+	}
+	return snapshot, nil
+}
+
+func newBackend(readOnly bool) (Backender, error) {
+	processors, err := newProcessors(readOnly)
+	if err != nil {
+		return nil, err
+	}
+	var templates *AttrTemplates
+	if opts.Templates != "" {
+		if templates, err = LoadAttrTemplates(opts.Templates); err != nil {
+			return nil, err
+		}
+	}
+
+	var journal *passwordJournal
+	if opts.PasswordJournal != "" || opts.PasswordEndpoint != "" {
+		if journal, err = NewPasswordJournal(opts.PasswordJournal, opts.PasswordEndpoint); err != nil {
+			return nil, err
+		}
+	}
+
+	backends := make([]Backender, 0)
+	if len(opts.LocalFiles) > 0 {
+		if backend, err := NewLocalFileBackend(opts.LocalFiles, &SnapshotConfig{processors: processors, templates: templates, journal: journal}); err != nil {
+			return nil, err
+		} else {
+			backends = append(backends, backend)
+		}
+	}
+	if len(opts.Files) > 0 {
+		if snapshot, err := newSnapshotConfig(processors, templates, journal, readOnly); err != nil {
+			return nil, err
+		} else if backend, err := NewLocalFileBackend(opts.Files, snapshot); err != nil {
+			return nil, err
+		} else {
+			backends = append(backends, backend)
+		}
+	}
+	if len(opts.HtpasswdFiles) > 0 {
+		if backend, err := NewHtpasswdBackend(opts.HtpasswdFiles, opts.GroupFiles, &SnapshotConfig{processors: processors, templates: templates}); err != nil {
+			return nil, err
+		} else {
+			backends = append(backends, backend)
+		}
+	}
+
+	var backend Backender
+	switch len(backends) {
+	case 0:
+		return nil, fmt.Errorf("no user/group data configured, use --file or --htpasswd-file")
+	case 1:
+		backend = backends[0]
+	default:
+		if composite, err := NewCompositeBackend(opts.Precedence, backends...); err != nil {
+			return nil, err
+		} else {
+			if err := checkIdCollisions(composite); err != nil {
+				log.Errorf("error checking ids of merged backends: %s", err.Error())
+			}
+			backend = composite
+		}
+	}
+
+	if opts.Schema != SchemaNone {
+		return NewPosixBackend(backend, opts.Schema, opts.HomeDirectory, opts.LoginShell, opts.DefaultGid)
 	}
+	return backend, nil
+}
+
+type checkConfigCommand struct{}
 
+func (c *checkConfigCommand) Execute(args []string) error {
+	setLogLevel()
+	if backend, err := newBackend(true); err != nil {
+		return err
+	} else if err := checkIdCollisions(backend); err != nil {
+		return err
+	}
+	if opts.ACL != "" {
+		if _, err := LoadACL(opts.ACL); err != nil {
+			return err
+		}
+	}
+	fmt.Println("config ok")
+	return nil
+}
+
+func setLogLevel() {
 	if opts.Silent {
 		logging.SetLevel(logging.CRITICAL, "")
 	} else if len(opts.Verbose) == 0 {
@@ -45,22 +220,88 @@ func main() {
 	} else {
 		logging.SetLevel(logging.DEBUG, "")
 	}
+}
+
+func main() {
+	parser := flags.NewParser(&opts, flags.Default)
+	parser.SubcommandsOptional = true
+	parser.AddCommand("encrypt", "Encrypt a snapshot", "Encrypt a plaintext snapshot for use with --snapshot-key", &encryptCommand{})
+	parser.AddCommand("check-config", "Check config", "Load all configured users and groups and report errors", &checkConfigCommand{})
+	if _, err := parser.Parse(); err != nil {
+		os.Exit(1)
+	} else if parser.Active != nil {
+		os.Exit(0)
+	}
 
-	if backend, err := NewLocalFileBackend(opts.Files); err != nil {
+	if opts.Version {
+		fmt.Printf("aldapd version: %s\n", VERSION)
+		os.Exit(0)
+	}
+
+	setLogLevel()
+
+	var acl *ACL
+	if opts.ACL != "" {
+		var err error
+		if acl, err = LoadACL(opts.ACL); err != nil {
+			log.Panicf("error loading acl: %s", err.Error())
+		}
+	}
+
+	var bindLimiter, searchLimiter *RateLimiter
+	if opts.BindRate > 0 {
+		bindLimiter = NewRateLimiter(opts.BindRate, opts.RateBurst)
+	}
+	if opts.SearchRate > 0 {
+		searchLimiter = NewRateLimiter(opts.SearchRate, opts.RateBurst)
+	}
+
+	ppolicy, err := NewPasswordPolicy(opts.PasswordMaxAge, opts.PasswordExpireWarning, opts.PasswordGraceLogins, opts.PasswordGraceFile)
+	if err != nil {
+		log.Panicf("error loading password policy: %s", err.Error())
+	}
+
+	var lockout *Lockout
+	if opts.LockoutThreshold > 0 {
+		lockout = NewLockout(opts.LockoutThreshold, opts.LockoutDuration, opts.LockoutPerIP)
+	}
+
+	if backend, err := newBackend(false); err != nil {
 		log.Panicf("error initializing backend: %s", err.Error())
 	} else {
 		c := &Config{
 			listenAddr:    opts.ListenAddr,
 			listenPort:    opts.ListenPort,
+			maxConns:      opts.MaxConns,
+			maxConnsPerIP: opts.MaxConnsPerIP,
+			idleTimeout:   opts.IdleTimeout,
+			bindLimiter:   bindLimiter,
+			searchLimiter: searchLimiter,
 			allowAnonBind: opts.AllowAnonBind,
 			baseDn:        opts.BaseDn,
-			peopleDn:      fmt.Sprintf("ou=people,%s", opts.BaseDn),
-			groupsDn:      fmt.Sprintf("ou=groups,%s", opts.BaseDn),
+			peopleDn:      fmt.Sprintf("ou=%s,%s", opts.PeopleOu, opts.BaseDn),
+			groupsDn:      fmt.Sprintf("ou=%s,%s", opts.GroupsOu, opts.BaseDn),
+			servicesDn:    fmt.Sprintf("ou=%s,%s", opts.ServicesOu, opts.BaseDn),
+			userRdnAttr:   opts.UserRdnAttr,
+			groupRdnAttr:  opts.GroupRdnAttr,
+			bindAttrs:     opts.BindAttrs,
+			inactive:      opts.InactiveAccounts,
+			writers:       opts.Writers,
+			acl:           acl,
+			lockout:       lockout,
+			ppolicy:       ppolicy,
 			backend:       backend,
 		}
 
 		s := NewServer(c)
 		go s.signalHandler()
+		if opts.AdminAddress != "" {
+			go func() {
+				if err := s.ListenAndServeAdmin(opts.AdminAddress, opts.AdminInsecure); err != nil {
+					log.Errorf("error starting admin interface: %s", err.Error())
+				}
+			}()
+		}
 		if err := s.ListenAndServe(); err != nil {
 			log.Errorf("error starting LDAP server: %s", err.Error())
 		}
@@ -1,5 +1,15 @@
 package main
 
+import "time"
+
+const (
+	// MatchingRuleInChain is LDAP_MATCHING_RULE_IN_CHAIN, matching group memberships transitively.
+	// Backends receive filter keys using it as "memberOf:1.2.840.113556.1.4.1941" or "member:1.2.840.113556.1.4.1941".
+	MatchingRuleInChain = "1.2.840.113556.1.4.1941"
+	// MemberGroup is the filter key backends receive for member filters with group DNs, optionally with MatchingRuleInChain.
+	MemberGroup = "memberGroup"
+)
+
 type Backender interface {
 	Check(username, password string) (bool, error)
 	Users(filterKey, filterValue string) ([]User, error)
@@ -7,14 +17,50 @@ type Backender interface {
 	Reload() error
 }
 
+// RollBacker is implemented by backends able to go back to previously loaded data.
+type RollBacker interface {
+	Rollback() error
+}
+
+// SnapshotWriter is implemented by backends persisting changes of users and groups.
+// Changes are applied to the data as stored, before processing and templating.
+type SnapshotWriter interface {
+	Writable() bool
+	Write(change func(data *BackendData) error) error
+}
+
+// ServiceBackender is implemented by backends serving service accounts.
+// Service accounts may bind but are no users, they are not members of groups.
+type ServiceBackender interface {
+	Services() ([]User, error)
+	CheckService(name, password string) (bool, error)
+}
+
 type User struct {
-	Name     string              `json:"name"`
-	Groups   []string            `json:",-"`
-	Attr     map[string][]string `json:"attr"`
-	Password string              `json:"password"`
+	Name              string              `json:"name"`
+	Groups            []string            `json:"-"`
+	Attr              map[string][]string `json:"attr"`
+	Password          string              `json:"password"`
+	Disabled          bool                `json:"disabled,omitempty"`
+	ValidFrom         *time.Time          `json:"validFrom,omitempty"`
+	ValidUntil        *time.Time          `json:"validUntil,omitempty"`
+	PasswordChangedAt *time.Time          `json:"passwordChangedAt,omitempty"`
+	MaxAge            int64               `json:"maxAge,omitempty"`
+	CreatedAt         time.Time           `json:"-"`
+	ModifiedAt        time.Time           `json:"-"`
+}
+
+// active reports whether the account is enabled and within its validity window.
+func (u *User) active(now time.Time) bool {
+	return !u.Disabled && (u.ValidFrom == nil || !now.Before(*u.ValidFrom)) && (u.ValidUntil == nil || now.Before(*u.ValidUntil))
 }
 
 type Group struct {
-	Name    string   `json:"name"`
-	Members []string `json:"member"`
+	Name         string              `json:"name"`
+	Members      []string            `json:"member"`
+	MemberGroups []string            `json:"member_group"`
+	Attr         map[string][]string `json:"attr"`
+	Groups       []string            `json:"-"`
+	CreatedAt    time.Time           `json:"-"`
+	ModifiedAt   time.Time           `json:"-"`
 }
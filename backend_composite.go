@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	PrecedenceFirst = "first"
+	PrecedenceLast  = "last"
+	PrecedenceMerge = "merge"
+)
+
+type compositeBackend struct {
+	backends   []Backender
+	precedence string
+}
+
+// NewCompositeBackend combines several backends, ordered by priority.
+// Name collisions between users or groups of different backends are resolved by precedence:
+// "first" serves the entry of the backend with the highest priority, "last" the one with the lowest priority
+// and "merge" combines group members and user group memberships of all backends.
+func NewCompositeBackend(precedence string, backends ...Backender) (*compositeBackend, error) {
+	switch precedence {
+	case PrecedenceFirst, PrecedenceLast, PrecedenceMerge:
+		return &compositeBackend{backends: backends, precedence: precedence}, nil
+	default:
+		return nil, fmt.Errorf("unknown precedence %q", precedence)
+	}
+}
+
+func (b *compositeBackend) Check(username, password string) (bool, error) {
+	owners, err := b.owners(username)
+	if err != nil {
+		return false, err
+	}
+	for _, backend := range owners {
+		if ok, err := backend.Check(username, password); ok || err != nil {
+			return ok, err
+		} else if b.precedence != PrecedenceMerge || inactiveIn(backend, username) {
+			return false, nil
+		}
+	}
+	return false, nil
+}
+
+// inactiveIn reports whether the backend disables the user, other backends must not let them bind then.
+func inactiveIn(backend Backender, username string) bool {
+	users, err := backend.Users("cn", username)
+	return err == nil && len(users) > 0 && !users[0].active(time.Now())
+}
+
+// owners returns all backends knowing the user ordered by precedence.
+func (b *compositeBackend) owners(username string) ([]Backender, error) {
+	owners := make([]Backender, 0)
+	for _, backend := range b.backends {
+		if users, err := backend.Users("cn", username); err != nil {
+			return nil, err
+		} else if len(users) > 0 {
+			owners = append(owners, backend)
+		}
+	}
+	if b.precedence == PrecedenceLast {
+		for i, j := 0, len(owners)-1; i < j; i, j = i+1, j-1 {
+			owners[i], owners[j] = owners[j], owners[i]
+		}
+	}
+	return owners, nil
+}
+
+func (b *compositeBackend) Users(filterKey, filterValue string) ([]User, error) {
+	users := make([]User, 0)
+	index := make(map[string]int)
+	for _, backend := range b.backends {
+		if us, err := backend.Users(filterKey, filterValue); err != nil {
+			return nil, err
+		} else {
+			for _, u := range us {
+				if i, ok := index[u.Name]; !ok {
+					index[u.Name] = len(users)
+					users = append(users, u)
+				} else if b.precedence == PrecedenceLast {
+					users[i] = u
+				} else if b.precedence == PrecedenceMerge {
+					users[i] = mergeUsers(users[i], u)
+				}
+			}
+		}
+	}
+	return users, nil
+}
+
+// Services returns the service accounts of all backends, collisions are resolved by precedence.
+func (b *compositeBackend) Services() ([]User, error) {
+	services := make([]User, 0)
+	index := make(map[string]int)
+	for _, backend := range b.backends {
+		if sb, ok := backend.(ServiceBackender); !ok {
+			continue
+		} else if ss, err := sb.Services(); err != nil {
+			return nil, err
+		} else {
+			for _, service := range ss {
+				if i, ok := index[service.Name]; !ok {
+					index[service.Name] = len(services)
+					services = append(services, service)
+				} else if b.precedence == PrecedenceLast {
+					services[i] = service
+				}
+			}
+		}
+	}
+	return services, nil
+}
+
+// CheckService checks the password with the backend serving the service account by precedence.
+func (b *compositeBackend) CheckService(name, password string) (bool, error) {
+	backends := append([]Backender{}, b.backends...)
+	if b.precedence == PrecedenceLast {
+		for i, j := 0, len(backends)-1; i < j; i, j = i+1, j-1 {
+			backends[i], backends[j] = backends[j], backends[i]
+		}
+	}
+	for _, backend := range backends {
+		if sb, ok := backend.(ServiceBackender); !ok {
+			continue
+		} else if services, err := sb.Services(); err != nil {
+			return false, err
+		} else {
+			for _, service := range services {
+				if service.Name == name {
+					return sb.CheckService(name, password)
+				}
+			}
+		}
+	}
+	return false, nil
+}
+
+func (b *compositeBackend) Groups(filterKey, filterValue string) ([]Group, error) {
+	groups := make([]Group, 0)
+	index := make(map[string]int)
+	for _, backend := range b.backends {
+		if gs, err := backend.Groups(filterKey, filterValue); err != nil {
+			return nil, err
+		} else {
+			for _, g := range gs {
+				if i, ok := index[g.Name]; !ok {
+					index[g.Name] = len(groups)
+					groups = append(groups, g)
+				} else if b.precedence == PrecedenceLast {
+					groups[i] = g
+				} else if b.precedence == PrecedenceMerge {
+					groups[i] = mergeGroups(groups[i], g)
+				}
+			}
+		}
+	}
+	return groups, nil
+}
+
+func (b *compositeBackend) Reload() error {
+	var firstErr error
+	for i, backend := range b.backends {
+		if err := backend.Reload(); err != nil {
+			log.Errorf("error reloading backend #%d: %s", i, err.Error())
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if err := checkIdCollisions(b); err != nil {
+		log.Errorf("error checking ids of merged backends: %s", err.Error())
+	}
+	return firstErr
+}
+
+// ChangePassword changes the password in the backend serving the user.
+func (b *compositeBackend) ChangePassword(username, password string) error {
+	owners, err := b.owners(username)
+	if err != nil {
+		return err
+	}
+	for _, backend := range owners {
+		if c, ok := backend.(PasswordChanger); ok {
+			return c.ChangePassword(username, password)
+		} else if b.precedence != PrecedenceMerge {
+			break
+		}
+	}
+	return fmt.Errorf("backend of user %s does not support password changes", username)
+}
+
+func (b *compositeBackend) Writable() bool {
+	return b.writer() != nil
+}
+
+// Write changes the data of the first writable backend.
+func (b *compositeBackend) Write(change func(data *BackendData) error) error {
+	if w := b.writer(); w != nil {
+		return w.Write(change)
+	}
+	return fmt.Errorf("no backend is writable")
+}
+
+func (b *compositeBackend) writer() SnapshotWriter {
+	for _, backend := range b.backends {
+		if w, ok := backend.(SnapshotWriter); ok && w.Writable() {
+			return w
+		}
+	}
+	return nil
+}
+
+// Rollback rolls back all backends supporting it.
+// It fails only if none of the backends could be rolled back.
+func (b *compositeBackend) Rollback() error {
+	err := fmt.Errorf("no backend supports rollback")
+	rolledBack := false
+	for i, backend := range b.backends {
+		if r, ok := backend.(RollBacker); !ok {
+			continue
+		} else if e := r.Rollback(); e != nil {
+			log.Warningf("not rolling back backend #%d: %s", i, e.Error())
+			err = e
+		} else {
+			rolledBack = true
+		}
+	}
+	if rolledBack {
+		return nil
+	}
+	return err
+}
+
+// mergeUsers keeps name, attributes and password of the first user and adds group memberships of the second one.
+func mergeUsers(first, second User) User {
+	groups := append([]string{}, first.Groups...)
+	for _, g := range second.Groups {
+		groups = appendIfMissing(groups, g)
+	}
+	first.Groups = groups
+	first.Disabled = first.Disabled || second.Disabled
+	if second.ValidFrom != nil && (first.ValidFrom == nil || second.ValidFrom.After(*first.ValidFrom)) {
+		first.ValidFrom = second.ValidFrom
+	}
+	if second.ValidUntil != nil && (first.ValidUntil == nil || second.ValidUntil.Before(*first.ValidUntil)) {
+		first.ValidUntil = second.ValidUntil
+	}
+	if second.ModifiedAt.After(first.ModifiedAt) {
+		first.ModifiedAt = second.ModifiedAt
+	}
+	return first
+}
+
+func mergeGroups(first, second Group) Group {
+	members := append([]string{}, first.Members...)
+	for _, m := range second.Members {
+		members = appendIfMissing(members, m)
+	}
+	first.Members = members
+	if second.ModifiedAt.After(first.ModifiedAt) {
+		first.ModifiedAt = second.ModifiedAt
+	}
+	return first
+}
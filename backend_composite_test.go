@@ -0,0 +1,176 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	localTestConfig = `{
+	"users": [
+		{"name":"u1", "attr":{"a1":["local"]}, "password":"{SSHA}hNsogC9IKy6CFkQzyDSMPmOlAnxcc27o"},
+		{"name":"s1", "password":"{SSHA}hNsogC9IKy6CFkQzyDSMPmOlAnxcc27o"}
+],
+	"groups": [
+		{"name":"g1", "member": ["s1"]},
+		{"name":"services", "member": ["s1"]}
+]
+}`
+)
+
+func newTestCompositeBackend(t *testing.T, precedence string) (*compositeBackend, func()) {
+	local, _ := ioutil.TempFile(os.TempDir(), "aldapd-local-config")
+	local.WriteString(localTestConfig)
+	central, _ := ioutil.TempFile(os.TempDir(), "aldapd-config")
+	central.WriteString(validTestConfig)
+	cleanup := func() {
+		os.Remove(local.Name())
+		os.Remove(central.Name())
+	}
+
+	lb, err := NewLocalFileBackend([]string{local.Name()}, nil)
+	assert.NoError(t, err)
+	cb, err := NewLocalFileBackend([]string{central.Name()}, nil)
+	assert.NoError(t, err)
+	b, err := NewCompositeBackend(precedence, lb, cb)
+	assert.NoError(t, err)
+	return b, cleanup
+}
+
+func TestNewCompositeBackend_invalid_precedence(t *testing.T) {
+	_, err := NewCompositeBackend("foo")
+	assert.Error(t, err)
+}
+
+func TestCompositeBackend_Users_first(t *testing.T) {
+	b, cleanup := newTestCompositeBackend(t, PrecedenceFirst)
+	defer cleanup()
+
+	users, err := b.Users("", "")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"u1", "u2", "s1"}, nameOfUsers(users))
+
+	users, err = b.Users("cn", "u1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(users))
+	assert.Equal(t, []string{"local"}, users[0].Attr["a1"])
+	assert.Equal(t, 0, len(users[0].Groups))
+}
+
+func TestCompositeBackend_Users_last(t *testing.T) {
+	b, cleanup := newTestCompositeBackend(t, PrecedenceLast)
+	defer cleanup()
+
+	users, err := b.Users("cn", "u1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(users))
+	assert.Equal(t, []string{"v1"}, users[0].Attr["a1"])
+	assert.ElementsMatch(t, []string{"g1", "g2"}, users[0].Groups)
+}
+
+func TestCompositeBackend_Users_filterByGroup(t *testing.T) {
+	b, cleanup := newTestCompositeBackend(t, PrecedenceMerge)
+	defer cleanup()
+
+	users, err := b.Users("memberOf", "g1")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"u1", "u2", "s1"}, nameOfUsers(users))
+}
+
+func TestCompositeBackend_Groups_merge(t *testing.T) {
+	b, cleanup := newTestCompositeBackend(t, PrecedenceMerge)
+	defer cleanup()
+
+	groups, err := b.Groups("", "")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"g1", "g2", "services"}, nameOfGroups(groups))
+	for _, g := range groups {
+		if g.Name == "g1" {
+			assert.ElementsMatch(t, []string{"s1", "u1", "u2"}, g.Members)
+		}
+	}
+
+	groups, err = b.Groups("member", "s1")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"g1", "services"}, nameOfGroups(groups))
+}
+
+func TestCompositeBackend_Groups_first(t *testing.T) {
+	b, cleanup := newTestCompositeBackend(t, PrecedenceFirst)
+	defer cleanup()
+
+	groups, err := b.Groups("", "")
+	assert.NoError(t, err)
+	for _, g := range groups {
+		if g.Name == "g1" {
+			assert.ElementsMatch(t, []string{"s1"}, g.Members)
+		}
+	}
+}
+
+func TestCompositeBackend_Check(t *testing.T) {
+	b, cleanup := newTestCompositeBackend(t, PrecedenceFirst)
+	defer cleanup()
+
+	r, err := b.Check("s1", "foo")
+	assert.NoError(t, err)
+	assert.True(t, r)
+
+	r, err = b.Check("u1", "foo")
+	assert.NoError(t, err)
+	assert.True(t, r)
+
+	r, err = b.Check("u2", "foo")
+	assert.NoError(t, err)
+	assert.False(t, r)
+
+	r, err = b.Check("u3", "foo")
+	assert.NoError(t, err)
+	assert.False(t, r)
+}
+
+func TestCompositeBackend_Check_last(t *testing.T) {
+	b, cleanup := newTestCompositeBackend(t, PrecedenceLast)
+	defer cleanup()
+
+	// u1's central password is not a supported hash, the local one is shadowed
+	r, err := b.Check("u1", "foo")
+	assert.NoError(t, err)
+	assert.False(t, r)
+}
+
+func TestCompositeBackend_Reload(t *testing.T) {
+	b, cleanup := newTestCompositeBackend(t, PrecedenceFirst)
+	defer cleanup()
+
+	assert.NoError(t, b.Reload())
+
+	cleanup()
+	assert.Error(t, b.Reload())
+}
+
+func TestCompositeBackend_Check_mergeDisabled(t *testing.T) {
+	local, _ := ioutil.TempFile(os.TempDir(), "aldapd-local-config")
+	defer os.Remove(local.Name())
+	local.WriteString(`{"users": [{"name":"u1", "disabled": true}]}`)
+	central, _ := ioutil.TempFile(os.TempDir(), "aldapd-config")
+	defer os.Remove(central.Name())
+	central.WriteString(`{"users": [{"name":"u1", "password":"{SSHA}hNsogC9IKy6CFkQzyDSMPmOlAnxcc27o", "validUntil":"2100-01-01T00:00:00Z"}]}`)
+
+	lb, _ := NewLocalFileBackend([]string{local.Name()}, nil)
+	cb, _ := NewLocalFileBackend([]string{central.Name()}, nil)
+	b, _ := NewCompositeBackend(PrecedenceMerge, lb, cb)
+
+	// disabling a user at a host overrides the central snapshot
+	r, err := b.Check("u1", "foo")
+	assert.NoError(t, err)
+	assert.False(t, r)
+
+	users, _ := b.Users("cn", "u1")
+	assert.True(t, users[0].Disabled)
+	assert.Equal(t, "2100-01-01T00:00:00Z", users[0].ValidUntil.Format(time.RFC3339))
+}
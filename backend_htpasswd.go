@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+type htpasswdBackend struct {
+	localFileBackend
+	htpasswdFiles []string
+	groupFiles    []string
+}
+
+// NewHtpasswdBackend serves users and passwords from apache htpasswd files
+// and group memberships from files in /etc/group format.
+// Of the snapshot config only processors and templates are used.
+func NewHtpasswdBackend(htpasswdFiles, groupFiles []string, snapshot *SnapshotConfig) (*htpasswdBackend, error) {
+	if snapshot == nil {
+		snapshot = &SnapshotConfig{}
+	}
+	b := &htpasswdBackend{htpasswdFiles: htpasswdFiles, groupFiles: groupFiles}
+	b.snapshot = &SnapshotConfig{processors: snapshot.processors, templates: snapshot.templates}
+	return b, b.Reload()
+}
+
+func (b *htpasswdBackend) Reload() error {
+	usersByName := make(map[string]*User)
+	groupsByName := make(map[string]*Group)
+	for _, f := range b.htpasswdFiles {
+		log.Infof("loading users from htpasswd file %s", f)
+		if err := readColonSeparatedFile(f, 2, func(fields []string) {
+			log.Debugf("adding user %q", fields[0])
+			usersByName[fields[0]] = &User{Name: fields[0], Password: fields[1]}
+		}); err != nil {
+			return err
+		}
+	}
+	for _, f := range b.groupFiles {
+		log.Infof("loading groups from group file %s", f)
+		if err := readColonSeparatedFile(f, 4, func(fields []string) {
+			members := make([]string, 0)
+			for _, m := range strings.Split(fields[3], ",") {
+				if m = strings.TrimSpace(m); m != "" {
+					members = append(members, m)
+				}
+			}
+			log.Debugf("adding group %q with %d members", fields[0], len(members))
+			groupsByName[fields[0]] = &Group{Name: fields[0], Members: members}
+		}); err != nil {
+			return err
+		}
+	}
+
+	return b.update(usersByName, groupsByName, nil, b.modTime())
+}
+
+// modTime returns the time the htpasswd and group files were last modified.
+func (b *htpasswdBackend) modTime() time.Time {
+	var modTime time.Time
+	for _, f := range append(append([]string{}, b.htpasswdFiles...), b.groupFiles...) {
+		if info, err := os.Stat(f); err == nil && info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	return modTime
+}
+
+// readColonSeparatedFile calls add for every line of the file, skipping empty lines and comments.
+func readColonSeparatedFile(name string, numFields int, add func(fields []string)) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for i := 1; scanner.Scan(); i++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, ":", numFields)
+		if len(fields) != numFields || fields[0] == "" {
+			return fmt.Errorf("%s:%d: expected %d colon separated fields", name, i, numFields)
+		}
+		add(fields)
+	}
+	return scanner.Err()
+}
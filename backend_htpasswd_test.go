@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	validTestHtpasswd = `# comment
+u1:$apr1$abcdefgh$awk9jawvrNMt/NFJ.cswm.
+u2:{SHA}C+7Hteo/D9vJXQ3UfzxbwnXaijM=
+`
+	validTestGroupFile = `g1:x:1000:u1,u2
+g2:x:1001:u1
+g3:x:1002:
+`
+)
+
+func TestNewHtpasswdBackend_missing_file(t *testing.T) {
+	_, err := NewHtpasswdBackend([]string{"/tmp/missing"}, []string{}, nil)
+	assert.Error(t, err)
+}
+
+func TestNewHtpasswdBackend_invalid_group_file(t *testing.T) {
+	f, _ := ioutil.TempFile(os.TempDir(), "aldapd-group")
+	defer os.Remove(f.Name())
+	f.WriteString("g1:x")
+
+	_, err := NewHtpasswdBackend([]string{}, []string{f.Name()}, nil)
+	assert.Error(t, err)
+}
+
+func TestNewHtpasswdBackend(t *testing.T) {
+	fp, _ := ioutil.TempFile(os.TempDir(), "aldapd-htpasswd")
+	defer os.Remove(fp.Name())
+	fp.WriteString(validTestHtpasswd)
+	fg, _ := ioutil.TempFile(os.TempDir(), "aldapd-group")
+	defer os.Remove(fg.Name())
+	fg.WriteString(validTestGroupFile)
+
+	b, err := NewHtpasswdBackend([]string{fp.Name()}, []string{fg.Name()}, nil)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 2, len(b.usersByName))
+	assert.ElementsMatch(t, []string{"g1", "g2"}, b.usersByName["u1"].Groups)
+	assert.ElementsMatch(t, []string{"g1"}, b.usersByName["u2"].Groups)
+
+	assert.Equal(t, 3, len(b.groupsByName))
+	assert.ElementsMatch(t, []string{"u1", "u2"}, b.groupsByName["g1"].Members)
+	assert.Equal(t, 0, len(b.groupsByName["g3"].Members))
+
+	for _, u := range []string{"u1", "u2"} {
+		r, err := b.Check(u, "foo")
+		assert.NoError(t, err)
+		assert.True(t, r, "for '%s'", u)
+
+		r, err = b.Check(u, "bar")
+		assert.NoError(t, err)
+		assert.False(t, r, "for '%s'", u)
+	}
+
+	users, err := b.Users("memberOf", "g2")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"u1"}, nameOfUsers(users))
+}
@@ -1,34 +1,67 @@
 package main
 
 import (
-	"bytes"
-	"crypto/sha1"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"reflect"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 )
 
 type BackendData struct {
-	Users  []*User  `json:"users"`
-	Groups []*Group `json:"groups"`
+	Version     int64          `json:"version"`
+	GeneratedAt time.Time      `json:"generated_at"`
+	Templates   *AttrTemplates `json:"templates"`
+	Users       []*User        `json:"users"`
+	Groups      []*Group       `json:"groups"`
+	Services    []*User        `json:"services,omitempty"`
+}
+
+// SnapshotConfig configures how snapshot files are checked and processed before being loaded and where they are kept.
+type SnapshotConfig struct {
+	publicKey      *minisignPublicKey
+	key            []byte
+	allowDowngrade bool
+	historyDir     string
+	historySize    int
+	processors     []DataProcessor
+	templates      *AttrTemplates
+	journal        *passwordJournal
+	writable       bool
+}
+
+// DataProcessor checks or completes users and groups after loading, before they are indexed.
+type DataProcessor interface {
+	Process(usersByName map[string]*User, groupsByName map[string]*Group) error
 }
 
 type localFileBackend struct {
 	sync.RWMutex
+	writeLock    sync.Mutex
 	files        []string
+	snapshot     *SnapshotConfig
+	version      int64
+	generatedAt  time.Time
 	users        []User
 	usersByName  map[string]*User
 	usersByAttr  map[string][]User
 	groups       []Group
 	groupsByName map[string]*Group
 	groupsByAttr map[string][]Group
+	services     map[string]*User
 }
 
-func NewLocalFileBackend(files []string) (*localFileBackend, error) {
-	b := &localFileBackend{files: files}
+func NewLocalFileBackend(files []string, snapshot *SnapshotConfig) (*localFileBackend, error) {
+	if snapshot == nil {
+		snapshot = &SnapshotConfig{}
+	}
+	if snapshot.writable && (len(files) != 1 || snapshot.publicKey != nil || snapshot.key != nil) {
+		return nil, fmt.Errorf("writes need a single unsigned and unencrypted snapshot file")
+	}
+	b := &localFileBackend{files: files, snapshot: snapshot}
 	return b, b.Reload()
 }
 
@@ -36,15 +69,62 @@ func (b *localFileBackend) Check(username, password string) (bool, error) {
 	b.RLock()
 	defer b.RUnlock()
 
-	if user, ok := b.usersByName[username]; !ok {
+	if user, ok := b.userByName(username); !ok {
 		return false, nil
 	} else if user.Password == "" {
 		return false, nil
-	} else if len(user.Password) == 38 && strings.HasPrefix(user.Password, "{SSHA}") {
-		return checkPasswordSSHA(username, password, user.Password)
+	} else if !user.active(time.Now()) {
+		log.Warningf("refusing bind of inactive user %s", username)
+		return false, nil
 	} else {
-		log.Warningf("unknown password hash method for user %s", username)
+		return checkPassword(username, password, user.Password)
+	}
+}
+
+// userByName prefers the exact name and falls back to the single user matching it case-insensitively.
+func (b *localFileBackend) userByName(name string) (*User, bool) {
+	if user, ok := b.usersByName[name]; ok {
+		return user, true
+	}
+	var found *User
+	for userName, user := range b.usersByName {
+		if strings.EqualFold(userName, name) {
+			if found != nil {
+				return nil, false
+			}
+			found = user
+		}
+	}
+	return found, found != nil
+}
+
+func (b *localFileBackend) Services() ([]User, error) {
+	b.RLock()
+	defer b.RUnlock()
+
+	names := make([]string, 0, len(b.services))
+	for name := range b.services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	services := make([]User, len(names))
+	for i, name := range names {
+		services[i] = *b.services[name]
+	}
+	return services, nil
+}
+
+func (b *localFileBackend) CheckService(name, password string) (bool, error) {
+	b.RLock()
+	defer b.RUnlock()
+
+	if service, ok := b.services[name]; !ok || service.Password == "" {
+		return false, nil
+	} else if !service.active(time.Now()) {
+		log.Warningf("refusing bind of inactive service %s", name)
 		return false, nil
+	} else {
+		return checkPassword(name, password, service.Password)
 	}
 }
 
@@ -55,9 +135,7 @@ func (b *localFileBackend) Users(filterKey, filterValue string) ([]User, error)
 	if filterKey == "" || filterValue == "" || filterValue == "*" {
 		return b.users, nil
 	} else if filterKey == "cn" {
-		return []User{
-			*b.usersByName[filterValue],
-		}, nil
+		return b.filterUsersByName(filterValue), nil
 	} else {
 		cacheKey := cacheKey(filterKey, filterValue)
 		if users, ok := b.usersByAttr[cacheKey]; ok {
@@ -72,30 +150,46 @@ func (b *localFileBackend) Users(filterKey, filterValue string) ([]User, error)
 	}
 }
 
+// filterUsersByName prefers the exact name and falls back to matching names case-insensitively.
+func (b *localFileBackend) filterUsersByName(name string) []User {
+	users := make([]User, 0)
+	if user, ok := b.usersByName[name]; ok {
+		return append(users, *user)
+	}
+	cn := attributeType("cn")
+	for _, u := range b.users {
+		if cn.Match(u.Name, name) {
+			users = append(users, u)
+		}
+	}
+	return users
+}
+
 func (b *localFileBackend) filterUsers(filterKey, filterValue string) []User {
-	if filterKey == "memberOf" {
+	if filterKey == "memberOf" || filterKey == "memberOf:"+MatchingRuleInChain {
 		return b.filterUsersByGroup(filterValue)
 	} else {
 		return b.filterUsersByAttr(filterKey, filterValue)
 	}
 }
 
+// filterUsersByGroup returns direct and nested members of the group.
 func (b *localFileBackend) filterUsersByGroup(name string) []User {
-	if g, ok := b.groupsByName[name]; ok {
-		users := make([]User, len(g.Members))
-		for i, n := range g.Members {
-			users[i] = *b.usersByName[n]
+	users := make([]User, 0)
+	cn := attributeType("cn")
+	for _, u := range b.users {
+		if cn.MatchAny(u.Groups, name) {
+			users = append(users, u)
 		}
-		return users
-	} else {
-		return []User{}
 	}
+	return users
 }
 
 func (b *localFileBackend) filterUsersByAttr(attr, value string) []User {
 	users := make([]User, 0)
+	t := attributeType(attr)
 	for _, u := range b.users {
-		if values, ok := u.Attr[attr]; ok && contains(values, value) {
+		if t.MatchAny(attrValues(u.Attr, attr), value) {
 			users = append(users, u)
 		}
 	}
@@ -108,26 +202,85 @@ func (b *localFileBackend) Groups(filterKey, filterValue string) ([]Group, error
 
 	if filterKey == "" || filterValue == "" || filterValue == "*" {
 		return b.groups, nil
-	} else if filterKey == "member" {
+	} else if filterKey == "cn" {
+		return b.filterGroupsByName(filterValue), nil
+	} else {
 		cacheKey := cacheKey(filterKey, filterValue)
 		if groups, ok := b.groupsByAttr[cacheKey]; ok {
 			log.Debugf("cache hit for filter %s on groups", cacheKey)
 			return groups, nil
 		} else {
 			log.Debugf("cache miss for filter %s on groups", cacheKey)
-			groups := b.filterGroupsByMember(filterValue)
+			groups := b.filterGroups(filterKey, filterValue)
 			b.groupsByAttr[cacheKey] = groups
 			return groups, nil
 		}
+	}
+}
+
+// filterGroupsByName prefers the exact name and falls back to matching names case-insensitively.
+func (b *localFileBackend) filterGroupsByName(name string) []Group {
+	groups := make([]Group, 0)
+	if group, ok := b.groupsByName[name]; ok {
+		return append(groups, *group)
+	}
+	cn := attributeType("cn")
+	for _, g := range b.groups {
+		if cn.Match(g.Name, name) {
+			groups = append(groups, g)
+		}
+	}
+	return groups
+}
+
+func (b *localFileBackend) filterGroups(filterKey, filterValue string) []Group {
+	if filterKey == "member" {
+		return b.filterGroupsByMember(filterValue, false)
+	} else if filterKey == "member:"+MatchingRuleInChain {
+		return b.filterGroupsByMember(filterValue, true)
+	} else if filterKey == MemberGroup {
+		return b.filterGroupsByMemberGroup(filterValue, false)
+	} else if filterKey == MemberGroup+":"+MatchingRuleInChain {
+		return b.filterGroupsByMemberGroup(filterValue, true)
 	} else {
-		return []Group{}, nil
+		return b.filterGroupsByAttr(filterKey, filterValue)
+	}
+}
+
+func (b *localFileBackend) filterGroupsByAttr(attr, value string) []Group {
+	groups := make([]Group, 0)
+	t := attributeType(attr)
+	for _, g := range b.groups {
+		if t.MatchAny(attrValues(g.Attr, attr), value) {
+			groups = append(groups, g)
+		}
 	}
+	return groups
+}
+
+func (b *localFileBackend) filterGroupsByMember(name string, inChain bool) []Group {
+	groups := make([]Group, 0)
+	cn := attributeType("cn")
+	users := b.filterUsersByName(name)
+	for _, group := range b.groups {
+		if cn.MatchAny(group.Members, name) {
+			groups = append(groups, group)
+		} else if inChain && len(users) > 0 && contains(users[0].Groups, group.Name) {
+			groups = append(groups, group)
+		}
+	}
+	return groups
 }
 
-func (b *localFileBackend) filterGroupsByMember(name string) []Group {
+// filterGroupsByMemberGroup returns the groups the group is a direct member of, or a direct or nested one in chain.
+func (b *localFileBackend) filterGroupsByMemberGroup(name string, inChain bool) []Group {
 	groups := make([]Group, 0)
+	cn := attributeType("cn")
+	children := b.filterGroupsByName(name)
 	for _, group := range b.groups {
-		if contains(group.Members, name) {
+		if cn.MatchAny(group.MemberGroups, name) {
+			groups = append(groups, group)
+		} else if inChain && len(children) > 0 && contains(children[0].Groups, group.Name) {
 			groups = append(groups, group)
 		}
 	}
@@ -135,16 +288,184 @@ func (b *localFileBackend) filterGroupsByMember(name string) []Group {
 }
 
 func (b *localFileBackend) Reload() error {
+	contents := make([][]byte, len(b.files))
+	sigs := make([][]byte, len(b.files))
+	for i, f := range b.files {
+		log.Infof("loading users and groups data from %s", f)
+		if content, sig, err := b.readSnapshot(f); err != nil {
+			return err
+		} else {
+			contents[i], sigs[i] = content, sig
+		}
+	}
+
+	if b.snapshot.historyDir != "" && b.snapshot.rejected(contents) {
+		return b.reloadRejected()
+	}
+	if err := b.load(b.files, contents, false); err != nil {
+		return err
+	}
+	if b.snapshot.historyDir != "" {
+		if err := b.snapshot.saveHistory(b.files, contents, sigs); err != nil {
+			log.Errorf("error saving snapshot history: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+// Rollback loads the previous snapshot from the snapshot history, verifying signatures like when reading snapshots.
+func (b *localFileBackend) Rollback() error {
+	if files, contents, err := b.snapshot.previousFromHistory(); err != nil {
+		return err
+	} else if err := b.verifySnapshots(files, contents); err != nil {
+		return err
+	} else if err := b.load(files, contents, true); err != nil {
+		return err
+	} else {
+		return b.snapshot.rejectLatestFromHistory()
+	}
+}
+
+// reloadRejected keeps the data rolled back to instead of loading a rejected snapshot again.
+// Without data loaded yet, e.g. after a restart, the latest generation of the history is loaded.
+func (b *localFileBackend) reloadRejected() error {
+	b.RLock()
+	loaded := b.usersByName != nil
+	b.RUnlock()
+	log.Warningf("not loading snapshot rolled back from, remove %s to accept it again", b.snapshot.rejectedFile())
+	if loaded {
+		return nil
+	} else if files, contents, err := b.snapshot.latestFromHistory(); err != nil {
+		return err
+	} else if err := b.verifySnapshots(files, contents); err != nil {
+		return err
+	} else {
+		return b.load(files, contents, true)
+	}
+}
+
+// ChangePassword records the password change in the journal and serves the new password right away.
+func (b *localFileBackend) ChangePassword(username, password string) error {
+	if b.snapshot.journal == nil {
+		return fmt.Errorf("password changes are not enabled")
+	}
+
+	b.RLock()
+	user, ok := b.userByName(username)
+	b.RUnlock()
+	if !ok {
+		return fmt.Errorf("unknown user %s", username)
+	}
+	username = user.Name
+	if err := b.snapshot.journal.record(username, password); err != nil {
+		log.Errorf("error recording password change of user %s: %s", username, err.Error())
+		return err
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	if user, ok := b.usersByName[username]; ok {
+		now := time.Now().UTC().Truncate(time.Second)
+		user.Password = password
+		user.PasswordChangedAt = &now
+		user.ModifiedAt = now
+		for i := range b.users {
+			if b.users[i].Name == username {
+				b.users[i] = *user
+			}
+		}
+		b.usersByAttr = make(map[string][]User)
+	}
+	log.Infof("changed password of user %s", username)
+	return nil
+}
+
+func (b *localFileBackend) Writable() bool {
+	return b.snapshot.writable
+}
+
+// Write changes the data of the snapshot file, replaces the file atomically and loads it with an increased version.
+// The file is restored if the changed data can't be loaded.
+func (b *localFileBackend) Write(change func(data *BackendData) error) error {
+	if !b.snapshot.writable {
+		return fmt.Errorf("backend is read only")
+	}
+	b.writeLock.Lock()
+	defer b.writeLock.Unlock()
+
+	f := b.files[0]
 	var data BackendData
+	original, err := ioutil.ReadFile(f)
+	if err != nil {
+		return err
+	} else if err := json.Unmarshal(original, &data); err != nil {
+		return err
+	}
+	// the new generation time supersedes recorded password changes, keep them in the data
+	if b.snapshot.journal != nil {
+		usersByName := make(map[string]*User, len(data.Users))
+		for _, user := range data.Users {
+			usersByName[user.Name] = user
+		}
+		b.snapshot.journal.overlay(usersByName, data.GeneratedAt)
+	}
+	if err := change(&data); err != nil {
+		return err
+	}
+
+	b.RLock()
+	if data.Version < b.version {
+		data.Version = b.version
+	}
+	b.RUnlock()
+	data.Version++
+	data.GeneratedAt = time.Now().UTC().Truncate(time.Second)
+
+	content, err := json.MarshalIndent(&data, "", "  ")
+	if err != nil {
+		return err
+	} else if err := writeFileAtomically(f, content); err != nil {
+		return err
+	} else if err := b.load(b.files, [][]byte{content}, false); err != nil {
+		if e := writeFileAtomically(f, original); e != nil {
+			log.Errorf("error restoring snapshot %s: %s", f, e.Error())
+		}
+		return err
+	}
+	log.Infof("wrote snapshot version %d to %s", data.Version, f)
+	if b.snapshot.historyDir != "" {
+		if err := b.snapshot.saveHistory(b.files, [][]byte{content}, nil); err != nil {
+			log.Errorf("error saving snapshot history: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+// load parses the raw snapshot contents and swaps in the new data.
+// Snapshots older than the current one are refused unless rolling back or downgrades are allowed.
+func (b *localFileBackend) load(files []string, contents [][]byte, rollback bool) error {
+	var version int64
+	var generatedAt time.Time
+	var templates *AttrTemplates
 	usersByName := make(map[string]*User)
 	groupsByName := make(map[string]*Group)
-	for _, f := range b.files {
-		log.Infof("loading users and groups data from %s", f)
-		if content, err := ioutil.ReadFile(f); err != nil {
+	services := make(map[string]*User)
+	for i, f := range files {
+		var data BackendData
+		if content, err := b.decodeSnapshot(f, contents[i]); err != nil {
 			return err
 		} else if err := json.Unmarshal(content, &data); err != nil {
 			return err
 		} else {
+			if data.Version > version {
+				version = data.Version
+			}
+			if data.GeneratedAt.After(generatedAt) {
+				generatedAt = data.GeneratedAt
+			}
+			if data.Templates != nil {
+				templates = mergeTemplates(templates, data.Templates)
+			}
 			for _, user := range data.Users {
 				log.Debugf("adding user %q", user.Name)
 				usersByName[user.Name] = user
@@ -153,18 +474,133 @@ func (b *localFileBackend) Reload() error {
 				log.Debugf("adding group %q with %d members", group.Name, len(group.Members))
 				groupsByName[group.Name] = group
 			}
+			for _, service := range data.Services {
+				log.Debugf("adding service %q", service.Name)
+				services[service.Name] = service
+			}
+		}
+	}
+
+	b.RLock()
+	current := b.version
+	b.RUnlock()
+	if version < current && !rollback && !b.snapshot.allowDowngrade {
+		err := fmt.Errorf("refusing to load snapshot version %d, older than current version %d", version, current)
+		log.Error(err.Error())
+		return err
+	}
+
+	if b.snapshot.journal != nil {
+		b.snapshot.journal.overlay(usersByName, generatedAt)
+	}
+
+	// rolled back entries are stamped with the time of the rollback so sync clients notice the changes
+	modifiedAt := generatedAt
+	if rollback {
+		modifiedAt = time.Now()
+	}
+	if err := b.update(usersByName, groupsByName, templates, modifiedAt); err != nil {
+		return err
+	}
+	b.Lock()
+	b.version = version
+	b.generatedAt = generatedAt
+	b.services = services
+	b.Unlock()
+	log.Infof("serving snapshot version %d generated at %s", version, generatedAt.Format(time.RFC3339))
+	return nil
+}
+
+// readSnapshot reads a snapshot file and with a public key its signature, verified against the content read.
+func (b *localFileBackend) readSnapshot(f string) ([]byte, []byte, error) {
+	content, err := ioutil.ReadFile(f)
+	if err != nil {
+		return nil, nil, err
+	} else if b.snapshot.publicKey == nil {
+		return content, nil, nil
+	} else if sig, err := ioutil.ReadFile(f + minisignSigSuffix); err != nil {
+		err = fmt.Errorf("error reading signature for %s: %s", f, err.Error())
+		log.Errorf("rejecting snapshot: %s", err.Error())
+		return nil, nil, err
+	} else if err := b.snapshot.publicKey.verify(content, sig); err != nil {
+		err = fmt.Errorf("invalid signature for %s: %s", f, err.Error())
+		log.Errorf("rejecting snapshot: %s", err.Error())
+		return nil, nil, err
+	} else {
+		return content, sig, nil
+	}
+}
+
+// verifySnapshots checks the signatures next to the snapshot files if a public key is configured.
+func (b *localFileBackend) verifySnapshots(files []string, contents [][]byte) error {
+	if b.snapshot.publicKey == nil {
+		return nil
+	}
+	for i, f := range files {
+		if err := b.snapshot.publicKey.verifyFile(f, contents[i]); err != nil {
+			log.Errorf("rejecting snapshot: %s", err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *localFileBackend) decodeSnapshot(f string, content []byte) ([]byte, error) {
+	if isEncryptedSnapshot(content) {
+		if b.snapshot.key == nil {
+			return nil, fmt.Errorf("snapshot %s is encrypted but no snapshot key is configured", f)
+		} else if content, err := decryptSnapshot(b.snapshot.key, content); err != nil {
+			log.Errorf("rejecting snapshot: error decrypting %s: %s", f, err.Error())
+			return nil, err
+		} else {
+			return content, nil
+		}
+	}
+	return content, nil
+}
+
+// update processes and links users to their groups and swaps in the new data.
+// Attribute templates of the data override the configured ones and are applied after the processors.
+// Memberships are resolved transitively, users and groups are members of all groups containing their groups.
+// New and changed entries get modifiedAt as timestamps, unchanged entries keep theirs.
+func (b *localFileBackend) update(usersByName map[string]*User, groupsByName map[string]*Group, templates *AttrTemplates, modifiedAt time.Time) error {
+	for _, p := range b.snapshot.processors {
+		if err := p.Process(usersByName, groupsByName); err != nil {
+			log.Errorf("rejecting users and groups data: %s", err.Error())
+			return err
+		}
+	}
+	mergeTemplates(b.snapshot.templates, templates).apply(usersByName, groupsByName)
+
+	parents := make(map[string][]string)
+	for _, group := range groupsByName {
+		for _, name := range group.MemberGroups {
+			if _, ok := groupsByName[name]; ok {
+				log.Debugf("adding group %s to group %s", name, group.Name)
+				parents[name] = appendIfMissing(parents[name], group.Name)
+			} else {
+				log.Warningf("ignoring unknown group %s as member of group %s", name, group.Name)
+			}
 		}
 	}
+	for _, group := range groupsByName {
+		group.Groups = ancestorGroups(group.Name, parents)
+	}
 
 	for _, group := range groupsByName {
 		for _, userName := range group.Members {
 			if user, ok := usersByName[userName]; ok {
 				log.Debugf("adding user %s to group %s", userName, group.Name)
 				user.Groups = appendIfMissing(user.Groups, group.Name)
+				for _, name := range group.Groups {
+					user.Groups = appendIfMissing(user.Groups, name)
+				}
 			}
 		}
 	}
 
+	b.stamp(usersByName, groupsByName, modifiedAt)
+
 	users := make([]User, len(usersByName))
 	i := 0
 	for _, v := range usersByName {
@@ -186,22 +622,66 @@ func (b *localFileBackend) Reload() error {
 	b.groupsByName = groupsByName
 	b.groupsByAttr = make(map[string][]Group)
 	b.Unlock()
-	log.Infof("loaded %d users and %d groups", len(b.usersByName), len(b.groupsByName))
+	log.Infof("loaded %d users and %d groups", len(usersByName), len(groupsByName))
 	return nil
 }
 
-func cacheKey(filterKey string, filterValue string) string {
-	return fmt.Sprintf("(%s=%s)", filterKey, filterValue)
+// stamp sets creation and modification timestamps by comparing the entries with the currently served ones.
+func (b *localFileBackend) stamp(usersByName map[string]*User, groupsByName map[string]*Group, modifiedAt time.Time) {
+	if modifiedAt.IsZero() {
+		modifiedAt = time.Now()
+	}
+	modifiedAt = modifiedAt.UTC().Truncate(time.Second)
+
+	b.RLock()
+	defer b.RUnlock()
+	for name, user := range usersByName {
+		user.CreatedAt, user.ModifiedAt = modifiedAt, modifiedAt
+		if prev, ok := b.usersByName[name]; ok {
+			user.CreatedAt = prev.CreatedAt
+			if user.Password == prev.Password && reflect.DeepEqual(user.Attr, prev.Attr) && reflect.DeepEqual(user.Groups, prev.Groups) &&
+				user.Disabled == prev.Disabled && reflect.DeepEqual(user.ValidFrom, prev.ValidFrom) && reflect.DeepEqual(user.ValidUntil, prev.ValidUntil) &&
+				reflect.DeepEqual(user.PasswordChangedAt, prev.PasswordChangedAt) && user.MaxAge == prev.MaxAge {
+				user.ModifiedAt = prev.ModifiedAt
+			}
+		}
+	}
+	for name, group := range groupsByName {
+		group.CreatedAt, group.ModifiedAt = modifiedAt, modifiedAt
+		if prev, ok := b.groupsByName[name]; ok {
+			group.CreatedAt = prev.CreatedAt
+			if reflect.DeepEqual(group.Members, prev.Members) && reflect.DeepEqual(group.MemberGroups, prev.MemberGroups) &&
+				reflect.DeepEqual(group.Attr, prev.Attr) && reflect.DeepEqual(group.Groups, prev.Groups) {
+				group.ModifiedAt = prev.ModifiedAt
+			}
+		}
+	}
 }
 
-func checkPasswordSSHA(username, password, storedPassword string) (bool, error) {
-	if byts, err := base64.StdEncoding.DecodeString(storedPassword[6:]); err != nil {
-		log.Errorf("error decoding password for user %s", username)
-		return false, err
-	} else {
-		salt := byts[20:]
-		hash := byts[:20]
-		check := sha1.Sum(append([]byte(password), salt...))
-		return bytes.Equal(hash, check[:]), nil
+// ancestorGroups returns all groups the group is a direct or nested member of.
+// Cycles are logged, a group is never reported as member of itself.
+func ancestorGroups(name string, parents map[string][]string) []string {
+	ancestors := make([]string, 0)
+	visited := map[string]bool{name: true}
+	cyclic := false
+	stack := append([]string{}, parents[name]...)
+	for len(stack) > 0 {
+		p := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if p == name && !cyclic {
+			log.Warningf("group %s is nested in itself", name)
+			cyclic = true
+		}
+		if visited[p] {
+			continue
+		}
+		visited[p] = true
+		ancestors = appendIfMissing(ancestors, p)
+		stack = append(stack, parents[p]...)
 	}
+	return ancestors
+}
+
+func cacheKey(filterKey string, filterValue string) string {
+	return fmt.Sprintf("(%s=%s)", filterKey, filterValue)
 }
@@ -4,6 +4,7 @@ import (
 	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -15,14 +16,14 @@ var (
 		{"name":"u2"}
 ],
 	"groups": [
-		{"name":"g1", "member": ["u1","u2"]},
+		{"name":"g1", "member": ["u1","u2"], "attr":{"gidNumber":["1001"], "objectClass":["posixGroup"]}},
 		{"name":"g2", "member": ["u1"]}
 ]
 }`
 )
 
 func TestNewLocalFileBackend_missing_file(t *testing.T) {
-	_, err := NewLocalFileBackend([]string{"/tmp/missing"})
+	_, err := NewLocalFileBackend([]string{"/tmp/missing"}, nil)
 	assert.Error(t, err)
 }
 
@@ -31,7 +32,7 @@ func TestNewLocalFileBackend_not_json(t *testing.T) {
 	defer os.Remove(f.Name())
 	f.WriteString("not json")
 
-	_, err := NewLocalFileBackend([]string{f.Name()})
+	_, err := NewLocalFileBackend([]string{f.Name()}, nil)
 	assert.Error(t, err)
 }
 
@@ -40,7 +41,7 @@ func TestNewLocalFileBackend(t *testing.T) {
 	defer os.Remove(f.Name())
 	f.WriteString(validTestConfig)
 
-	b, err := NewLocalFileBackend([]string{f.Name()})
+	b, err := NewLocalFileBackend([]string{f.Name()}, nil)
 	assert.NoError(t, err)
 
 	assert.Equal(t, 1, len(b.files))
@@ -72,7 +73,7 @@ func TestLocalFileBackend_Reload(t *testing.T) {
 	defer os.Remove(f.Name())
 	f.WriteString(validTestConfig)
 
-	b, err := NewLocalFileBackend([]string{f.Name()})
+	b, err := NewLocalFileBackend([]string{f.Name()}, nil)
 	assert.NoError(t, err)
 
 	lu := len(b.usersByName)
@@ -102,7 +103,7 @@ func TestLocalFileBackend_Users(t *testing.T) {
 	defer os.Remove(f.Name())
 	f.WriteString(validTestConfig)
 
-	b, err := NewLocalFileBackend([]string{f.Name()})
+	b, err := NewLocalFileBackend([]string{f.Name()}, nil)
 	assert.NoError(t, err)
 
 	users, err := b.Users("", "")
@@ -116,7 +117,7 @@ func TestLocalFileBackend_Users_filterByCn(t *testing.T) {
 	defer os.Remove(f.Name())
 	f.WriteString(validTestConfig)
 
-	b, err := NewLocalFileBackend([]string{f.Name()})
+	b, err := NewLocalFileBackend([]string{f.Name()}, nil)
 	assert.NoError(t, err)
 
 	users, err := b.Users("cn", "u1")
@@ -127,12 +128,25 @@ func TestLocalFileBackend_Users_filterByCn(t *testing.T) {
 	assert.Equal(t, []string{"v1"}, users[0].Attr["a1"])
 }
 
+func TestLocalFileBackend_Users_filterByCnMissing(t *testing.T) {
+	f, _ := ioutil.TempFile(os.TempDir(), "aldapd-config")
+	defer os.Remove(f.Name())
+	f.WriteString(validTestConfig)
+
+	b, err := NewLocalFileBackend([]string{f.Name()}, nil)
+	assert.NoError(t, err)
+
+	users, err := b.Users("cn", "u3")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(users))
+}
+
 func TestLocalFileBackend_Users_filterByGroupMultiple(t *testing.T) {
 	f, _ := ioutil.TempFile(os.TempDir(), "aldapd-config")
 	defer os.Remove(f.Name())
 	f.WriteString(validTestConfig)
 
-	b, err := NewLocalFileBackend([]string{f.Name()})
+	b, err := NewLocalFileBackend([]string{f.Name()}, nil)
 	assert.NoError(t, err)
 
 	for range []int{1, 2, 3} {
@@ -148,7 +162,7 @@ func TestLocalFileBackend_Users_filterByGroupSingle(t *testing.T) {
 	defer os.Remove(f.Name())
 	f.WriteString(validTestConfig)
 
-	b, err := NewLocalFileBackend([]string{f.Name()})
+	b, err := NewLocalFileBackend([]string{f.Name()}, nil)
 	assert.NoError(t, err)
 
 	for range []int{1, 2, 3} {
@@ -164,7 +178,7 @@ func TestLocalFileBackend_Users_filterByGroupZero(t *testing.T) {
 	defer os.Remove(f.Name())
 	f.WriteString(validTestConfig)
 
-	b, err := NewLocalFileBackend([]string{f.Name()})
+	b, err := NewLocalFileBackend([]string{f.Name()}, nil)
 	assert.NoError(t, err)
 
 	for range []int{1, 2, 3} {
@@ -179,7 +193,7 @@ func TestLocalFileBackend_Users_filterByAttr(t *testing.T) {
 	defer os.Remove(f.Name())
 	f.WriteString(validTestConfig)
 
-	b, err := NewLocalFileBackend([]string{f.Name()})
+	b, err := NewLocalFileBackend([]string{f.Name()}, nil)
 	assert.NoError(t, err)
 
 	for range []int{1, 2, 3} {
@@ -195,7 +209,7 @@ func TestLocalFileBackend_Groups(t *testing.T) {
 	defer os.Remove(f.Name())
 	f.WriteString(validTestConfig)
 
-	b, err := NewLocalFileBackend([]string{f.Name()})
+	b, err := NewLocalFileBackend([]string{f.Name()}, nil)
 	assert.NoError(t, err)
 
 	groups, err := b.Groups("", "")
@@ -209,7 +223,7 @@ func TestLocalFileBackend_Groups_filterByMemberMultiple(t *testing.T) {
 	defer os.Remove(f.Name())
 	f.WriteString(validTestConfig)
 
-	b, err := NewLocalFileBackend([]string{f.Name()})
+	b, err := NewLocalFileBackend([]string{f.Name()}, nil)
 	assert.NoError(t, err)
 
 	groups, err := b.Groups("member", "u1")
@@ -223,7 +237,7 @@ func TestLocalFileBackend_Groups_filterByMemberSingle(t *testing.T) {
 	defer os.Remove(f.Name())
 	f.WriteString(validTestConfig)
 
-	b, err := NewLocalFileBackend([]string{f.Name()})
+	b, err := NewLocalFileBackend([]string{f.Name()}, nil)
 	assert.NoError(t, err)
 
 	groups, err := b.Groups("member", "u2")
@@ -237,7 +251,7 @@ func TestLocalFileBackend_Groups_filterByMemberZero(t *testing.T) {
 	defer os.Remove(f.Name())
 	f.WriteString(validTestConfig)
 
-	b, err := NewLocalFileBackend([]string{f.Name()})
+	b, err := NewLocalFileBackend([]string{f.Name()}, nil)
 	assert.NoError(t, err)
 
 	groups, err := b.Groups("member", "u3")
@@ -250,12 +264,69 @@ func TestLocalFileBackend_Groups_filterByAttr(t *testing.T) {
 	defer os.Remove(f.Name())
 	f.WriteString(validTestConfig)
 
-	b, err := NewLocalFileBackend([]string{f.Name()})
+	b, err := NewLocalFileBackend([]string{f.Name()}, nil)
 	assert.NoError(t, err)
 
 	groups, err := b.Groups("foo", "bar")
 	assert.NoError(t, err)
 	assert.Equal(t, 0, len(groups))
+
+	for range []int{1, 2, 3} {
+		groups, err = b.Groups("gidNumber", "1001")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(groups))
+		assert.Equal(t, "g1", groups[0].Name)
+	}
+}
+
+func TestLocalFileBackend_Groups_filterByCn(t *testing.T) {
+	f, _ := ioutil.TempFile(os.TempDir(), "aldapd-config")
+	defer os.Remove(f.Name())
+	f.WriteString(validTestConfig)
+
+	b, err := NewLocalFileBackend([]string{f.Name()}, nil)
+	assert.NoError(t, err)
+
+	groups, err := b.Groups("cn", "g1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(groups))
+	assert.Equal(t, []string{"1001"}, groups[0].Attr["gidNumber"])
+
+	groups, err = b.Groups("cn", "g3")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(groups))
+}
+
+func TestLocalFileBackend_caseInsensitive(t *testing.T) {
+	f, _ := ioutil.TempFile(os.TempDir(), "aldapd-config")
+	defer os.Remove(f.Name())
+	f.WriteString(`{
+	"users": [
+		{"name":"Kevin", "attr":{"mail":["kevin@example.org"], "homeDirectory":["/home/kevin"]}}
+	],
+	"groups": [
+		{"name":"Admins", "member": ["Kevin"], "attr":{"gidNumber":["1001"]}}
+	]
+}`)
+
+	b, err := NewLocalFileBackend([]string{f.Name()}, nil)
+	assert.NoError(t, err)
+
+	for k, v := range map[string]string{"cn": "kevin", "mail": "Kevin@Example.ORG", "MAIL": "kevin@example.org", "memberOf": "admins"} {
+		users, err := b.Users(k, v)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(users), "for %s=%s", k, v)
+	}
+
+	users, err := b.Users("homeDirectory", "/home/Kevin")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(users))
+
+	for k, v := range map[string]string{"cn": "ADMINS", "gidNumber": "01001", "member": "kevin", "member:" + MatchingRuleInChain: "kevin"} {
+		groups, err := b.Groups(k, v)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(groups), "for %s=%s", k, v)
+	}
 }
 
 func TestLocalFileBackend_Check_invalids(t *testing.T) {
@@ -298,6 +369,25 @@ func TestLocalFileBackend_Check(t *testing.T) {
 	}
 }
 
+func TestLocalFileBackend_Check_caseInsensitive(t *testing.T) {
+	password := "{SSHA}hNsogC9IKy6CFkQzyDSMPmOlAnxcc27o"
+	b := &localFileBackend{
+		usersByName: map[string]*User{"Foo": {Name: "Foo", Password: password}},
+	}
+	r, err := b.Check("foo", "foo")
+	assert.NoError(t, err)
+	assert.True(t, r)
+
+	// ambiguous names need the exact case
+	b.usersByName["FOO"] = &User{Name: "FOO", Password: password}
+	r, err = b.Check("foo", "foo")
+	assert.NoError(t, err)
+	assert.False(t, r)
+	r, err = b.Check("FOO", "foo")
+	assert.NoError(t, err)
+	assert.True(t, r)
+}
+
 func nameOfUsers(users []User) []string {
 	names := make([]string, len(users))
 	for i, u := range users {
@@ -313,3 +403,110 @@ func nameOfGroups(groups []Group) []string {
 	}
 	return names
 }
+
+func TestLocalFileBackend_nestedGroups(t *testing.T) {
+	f, _ := ioutil.TempFile(os.TempDir(), "aldapd-config")
+	defer os.Remove(f.Name())
+	f.WriteString(`{
+	"users": [{"name":"u1"}, {"name":"u2"}],
+	"groups": [
+		{"name":"team", "member": ["u1"]},
+		{"name":"dept", "member": ["u2"], "member_group": ["team"]},
+		{"name":"company", "member_group": ["dept", "unknown"]},
+		{"name":"c1", "member": ["u2"], "member_group": ["c2"]},
+		{"name":"c2", "member_group": ["c1"]}
+	]
+}`)
+
+	b, err := NewLocalFileBackend([]string{f.Name()}, nil)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"company", "dept", "team"}, b.usersByName["u1"].Groups)
+	assert.Equal(t, []string{"c1", "c2", "company", "dept"}, b.usersByName["u2"].Groups)
+	assert.Equal(t, []string{"company", "dept"}, b.groupsByName["team"].Groups)
+	assert.Equal(t, []string{"c2"}, b.groupsByName["c1"].Groups)
+	assert.Equal(t, []string{"c1"}, b.groupsByName["c2"].Groups)
+
+	users, err := b.Users("memberOf", "company")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"u1", "u2"}, nameOfUsers(users))
+
+	users, err = b.Users("memberOf:"+MatchingRuleInChain, "team")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"u1"}, nameOfUsers(users))
+
+	groups, err := b.Groups("member", "u1")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"team"}, nameOfGroups(groups))
+
+	groups, err = b.Groups("member:"+MatchingRuleInChain, "u1")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"company", "dept", "team"}, nameOfGroups(groups))
+
+	groups, err = b.Groups(MemberGroup, "team")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"dept"}, nameOfGroups(groups))
+
+	groups, err = b.Groups(MemberGroup+":"+MatchingRuleInChain, "team")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"company", "dept"}, nameOfGroups(groups))
+}
+
+func TestLocalFileBackend_services(t *testing.T) {
+	f, _ := ioutil.TempFile(os.TempDir(), "aldapd-config")
+	defer os.Remove(f.Name())
+	f.WriteString(`{
+	"users": [{"name":"u1"}],
+	"groups": [{"name":"g1", "member": ["u1"]}],
+	"services": [
+		{"name":"nextcloud", "password":"{SSHA}hNsogC9IKy6CFkQzyDSMPmOlAnxcc27o"},
+		{"name":"backup"}
+	]
+}`)
+	f.Close()
+
+	b, err := NewLocalFileBackend([]string{f.Name()}, nil)
+	assert.NoError(t, err)
+
+	services, err := b.Services()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"backup", "nextcloud"}, nameOfUsers(services))
+
+	users, _ := b.Users("", "")
+	assert.Equal(t, []string{"u1"}, nameOfUsers(users))
+	r, _ := b.Check("nextcloud", "foo")
+	assert.False(t, r)
+
+	r, err = b.CheckService("nextcloud", "foo")
+	assert.NoError(t, err)
+	assert.True(t, r)
+	r, _ = b.CheckService("nextcloud", "bar")
+	assert.False(t, r)
+	r, _ = b.CheckService("backup", "")
+	assert.False(t, r)
+	r, _ = b.CheckService("u1", "")
+	assert.False(t, r)
+}
+
+func TestLocalFileBackend_Check_inactive(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	password := "{SSHA}hNsogC9IKy6CFkQzyDSMPmOlAnxcc27o"
+	cases := map[string]struct {
+		user     User
+		expected bool
+	}{
+		"active":      {User{Password: password, ValidFrom: &past, ValidUntil: &future}, true},
+		"disabled":    {User{Password: password, Disabled: true}, false},
+		"not yet":     {User{Password: password, ValidFrom: &future}, false},
+		"expired":     {User{Password: password, ValidUntil: &past}, false},
+		"no validity": {User{Password: password}, true},
+	}
+	for name, tc := range cases {
+		user := tc.user
+		b := &localFileBackend{usersByName: map[string]*User{"u1": &user}}
+		r, err := b.Check("u1", "foo")
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, r, "for %s", name)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	SchemaNone       = "none"
+	SchemaRFC2307    = "rfc2307"
+	SchemaRFC2307bis = "rfc2307bis"
+)
+
+type posixBackend struct {
+	backend       Backender
+	schema        string
+	homeDirectory string
+	loginShell    string
+	defaultGid    string
+}
+
+// NewPosixBackend adds posixAccount and posixGroup attributes to the users and groups of another backend.
+// uid and memberUid are derived from names, homeDirectory from a template replacing {name} with the user's name.
+// Users need a uidNumber and a gidNumber or defaultGid, groups a gidNumber to become posix objects.
+// Attributes set explicitly in the backend's data always win.
+func NewPosixBackend(backend Backender, schema, homeDirectory, loginShell, defaultGid string) (*posixBackend, error) {
+	if _, err := strconv.Atoi(defaultGid); defaultGid != "" && err != nil {
+		return nil, fmt.Errorf("invalid default gid %q", defaultGid)
+	}
+	switch schema {
+	case SchemaRFC2307, SchemaRFC2307bis:
+		return &posixBackend{
+			backend:       backend,
+			schema:        schema,
+			homeDirectory: homeDirectory,
+			loginShell:    loginShell,
+			defaultGid:    defaultGid,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown posix schema %q", schema)
+	}
+}
+
+func (b *posixBackend) Check(username, password string) (bool, error) {
+	return b.backend.Check(username, password)
+}
+
+// derivedUserAttrs are set by posixUser, the backend only knows explicitly set ones.
+var derivedUserAttrs = []string{"uid", "objectClass", "homeDirectory", "loginShell", "gidNumber"}
+
+func (b *posixBackend) Users(filterKey, filterValue string) ([]User, error) {
+	derived := contains(derivedUserAttrs, filterKey) && filterValue != "" && filterValue != "*"
+	backendKey, backendValue := filterKey, filterValue
+	if derived {
+		backendKey, backendValue = "", ""
+	}
+	if users, err := b.backend.Users(backendKey, backendValue); err != nil {
+		return nil, err
+	} else {
+		posixUsers := make([]User, 0, len(users))
+		t := attributeType(filterKey)
+		for _, u := range users {
+			if pu := b.posixUser(u); !derived || t.MatchAny(pu.Attr[filterKey], filterValue) {
+				posixUsers = append(posixUsers, pu)
+			}
+		}
+		return posixUsers, nil
+	}
+}
+
+func (b *posixBackend) posixUser(u User) User {
+	if len(u.Attr["uidNumber"]) == 0 {
+		log.Debugf("user %s has no uidNumber, not adding posixAccount", u.Name)
+		return u
+	} else if len(u.Attr["gidNumber"]) == 0 && b.defaultGid == "" {
+		log.Debugf("user %s has no gidNumber, not adding posixAccount", u.Name)
+		return u
+	}
+	attr := copyAttr(u.Attr)
+	attr["objectClass"] = appendIfMissing(append([]string{}, attr["objectClass"]...), "posixAccount")
+	setDefaultAttr(attr, "uid", u.Name)
+	setDefaultAttr(attr, "gidNumber", b.defaultGid)
+	setDefaultAttr(attr, "homeDirectory", strings.Replace(b.homeDirectory, "{name}", u.Name, -1))
+	setDefaultAttr(attr, "loginShell", b.loginShell)
+	u.Attr = attr
+	return u
+}
+
+// Groups filters objectClass on the derived groups, the backend only knows explicitly set classes.
+func (b *posixBackend) Groups(filterKey, filterValue string) ([]Group, error) {
+	derived := filterKey == "objectClass" && filterValue != "" && filterValue != "*"
+	backendKey, backendValue := filterKey, filterValue
+	if derived {
+		backendKey, backendValue = "", ""
+	}
+	posixOnly := filterKey == "memberUid"
+	if posixOnly {
+		if b.schema == SchemaRFC2307 {
+			backendKey = "member:" + MatchingRuleInChain
+		} else {
+			backendKey = "member"
+		}
+	}
+	if groups, err := b.backend.Groups(backendKey, backendValue); err != nil {
+		return nil, err
+	} else {
+		posixGroups := make([]Group, 0, len(groups))
+		for _, g := range groups {
+			if pg, err := b.posixGroup(g); err != nil {
+				return nil, err
+			} else if posixOnly && !contains(pg.Attr["objectClass"], "posixGroup") {
+				continue
+			} else if !derived || attributeType(filterKey).MatchAny(pg.Attr[filterKey], filterValue) {
+				posixGroups = append(posixGroups, pg)
+			}
+		}
+		return posixGroups, nil
+	}
+}
+
+// posixGroup adds memberUid for all direct members, with rfc2307 for nested members as well
+// because clients don't know about nested groups.
+func (b *posixBackend) posixGroup(g Group) (Group, error) {
+	if len(g.Attr["gidNumber"]) == 0 {
+		log.Debugf("group %s has no gidNumber, not adding posixGroup", g.Name)
+		return g, nil
+	}
+	attr := copyAttr(g.Attr)
+	attr["objectClass"] = appendIfMissing(append([]string{}, attr["objectClass"]...), "posixGroup")
+	if _, ok := attr["memberUid"]; !ok {
+		if b.schema == SchemaRFC2307bis {
+			attr["memberUid"] = g.Members
+		} else if members, err := b.backend.Users("memberOf", g.Name); err != nil {
+			return g, err
+		} else {
+			memberUids := make([]string, len(members))
+			for i, m := range members {
+				memberUids[i] = m.Name
+			}
+			attr["memberUid"] = memberUids
+		}
+	}
+	g.Attr = attr
+	return g, nil
+}
+
+func (b *posixBackend) Reload() error {
+	return b.backend.Reload()
+}
+
+func (b *posixBackend) Rollback() error {
+	if r, ok := b.backend.(RollBacker); ok {
+		return r.Rollback()
+	} else {
+		return fmt.Errorf("backend does not support rollback")
+	}
+}
+
+func (b *posixBackend) ChangePassword(username, password string) error {
+	if c, ok := b.backend.(PasswordChanger); ok {
+		return c.ChangePassword(username, password)
+	} else {
+		return fmt.Errorf("backend does not support password changes")
+	}
+}
+
+func (b *posixBackend) Writable() bool {
+	w, ok := b.backend.(SnapshotWriter)
+	return ok && w.Writable()
+}
+
+func (b *posixBackend) Write(change func(data *BackendData) error) error {
+	if w, ok := b.backend.(SnapshotWriter); ok {
+		return w.Write(change)
+	} else {
+		return fmt.Errorf("backend is read only")
+	}
+}
+
+func (b *posixBackend) Services() ([]User, error) {
+	if sb, ok := b.backend.(ServiceBackender); ok {
+		return sb.Services()
+	}
+	return []User{}, nil
+}
+
+func (b *posixBackend) CheckService(name, password string) (bool, error) {
+	if sb, ok := b.backend.(ServiceBackender); ok {
+		return sb.CheckService(name, password)
+	}
+	return false, nil
+}
+
+func copyAttr(attr map[string][]string) map[string][]string {
+	c := make(map[string][]string, len(attr))
+	for k, v := range attr {
+		c[k] = v
+	}
+	return c
+}
+
+func setDefaultAttr(attr map[string][]string, name, value string) {
+	if _, ok := attr[name]; !ok && value != "" {
+		attr[name] = []string{value}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	posixTestConfig = `{
+	"users": [
+		{"name":"u1", "attr":{"uidNumber":["1001"], "objectClass":["shadowAccount"]}},
+		{"name":"u2", "attr":{"uidNumber":["1002"], "gidNumber":["100"], "loginShell":["/bin/zsh"]}},
+		{"name":"u3"},
+		{"name":"u4", "attr":{"uidNumber":["1004"], "uid":["fourth"]}}
+],
+	"groups": [
+		{"name":"g1", "member": ["u1"], "member_group": ["g2"], "attr":{"gidNumber":["2001"]}},
+		{"name":"g2", "member": ["u2"], "attr":{"gidNumber":["2002"]}},
+		{"name":"g3", "member": ["u1"]}
+]
+}`
+)
+
+func newTestPosixBackend(t *testing.T, schema string) (*posixBackend, func()) {
+	f, _ := ioutil.TempFile(os.TempDir(), "aldapd-config")
+	f.WriteString(posixTestConfig)
+
+	lb, err := NewLocalFileBackend([]string{f.Name()}, nil)
+	assert.NoError(t, err)
+	b, err := NewPosixBackend(lb, schema, "/home/{name}", "/bin/bash", "2001")
+	assert.NoError(t, err)
+	return b, func() { os.Remove(f.Name()) }
+}
+
+func TestNewPosixBackend_invalid_schema(t *testing.T) {
+	_, err := NewPosixBackend(&TestBackend{}, "foo", "", "", "")
+	assert.Error(t, err)
+	_, err = NewPosixBackend(&TestBackend{}, SchemaRFC2307, "", "", "users")
+	assert.Error(t, err)
+}
+
+func TestPosixBackend_Users(t *testing.T) {
+	b, cleanup := newTestPosixBackend(t, SchemaRFC2307)
+	defer cleanup()
+
+	users, err := b.Users("uid", "u1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(users))
+	assert.Equal(t, map[string][]string{
+		"objectClass":   {"posixAccount", "shadowAccount"},
+		"uid":           {"u1"},
+		"uidNumber":     {"1001"},
+		"gidNumber":     {"2001"},
+		"homeDirectory": {"/home/u1"},
+		"loginShell":    {"/bin/bash"},
+	}, users[0].Attr)
+
+	users, err = b.Users("cn", "u2")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"100"}, users[0].Attr["gidNumber"])
+	assert.Equal(t, []string{"/bin/zsh"}, users[0].Attr["loginShell"])
+
+	users, err = b.Users("cn", "u3")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(users[0].Attr))
+
+	users, err = b.Users("homeDirectory", "/home/u2")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"u2"}, nameOfUsers(users))
+
+	users, err = b.Users("objectClass", "PosixAccount")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"u1", "u2", "u4"}, nameOfUsers(users))
+
+	// uid is derived from the name only for posix accounts without explicit uid
+	users, err = b.Users("uid", "fourth")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"u4"}, nameOfUsers(users))
+	users, err = b.Users("uid", "u4")
+	assert.NoError(t, err)
+	assert.Empty(t, users)
+	users, err = b.Users("uid", "u3")
+	assert.NoError(t, err)
+	assert.Empty(t, users)
+
+	// without default gid, users need an explicit gidNumber
+	b.defaultGid = ""
+	users, err = b.Users("objectClass", "posixAccount")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"u2"}, nameOfUsers(users))
+	b.defaultGid = "2001"
+
+	// backend data is not changed
+	users, err = b.backend.Users("cn", "u1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"shadowAccount"}, users[0].Attr["objectClass"])
+}
+
+func TestPosixBackend_Groups_rfc2307(t *testing.T) {
+	b, cleanup := newTestPosixBackend(t, SchemaRFC2307)
+	defer cleanup()
+
+	groups, err := b.Groups("cn", "g1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(groups))
+	assert.Equal(t, []string{"posixGroup"}, groups[0].Attr["objectClass"])
+	assert.ElementsMatch(t, []string{"u1", "u2"}, groups[0].Attr["memberUid"])
+
+	groups, err = b.Groups("memberUid", "u2")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"g1", "g2"}, nameOfGroups(groups))
+
+	groups, err = b.Groups("cn", "g3")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(groups[0].Attr))
+
+	groups, err = b.Groups("objectClass", "posixGroup")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"g1", "g2"}, nameOfGroups(groups))
+}
+
+func TestPosixBackend_Groups_rfc2307bis(t *testing.T) {
+	b, cleanup := newTestPosixBackend(t, SchemaRFC2307bis)
+	defer cleanup()
+
+	groups, err := b.Groups("cn", "g1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"u1"}, groups[0].Attr["memberUid"])
+
+	groups, err = b.Groups("memberUid", "u2")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"g2"}, nameOfGroups(groups))
+
+	groups, err = b.Groups("memberUid", "u1")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"g1"}, nameOfGroups(groups))
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// AttributeTypeAndValue is a single assertion of a relative distinguished name like cn=kevin.
+type AttributeTypeAndValue struct {
+	Type  string
+	Value string
+}
+
+// RDN is a relative distinguished name, multi-valued RDNs are joined with '+'.
+type RDN []AttributeTypeAndValue
+
+// DN is a distinguished name as defined by RFC 4514, starting with the most specific RDN.
+type DN []RDN
+
+// ParseDN parses a string representation of a DN as defined by RFC 4514.
+// Whitespace around types and values is ignored, escaped characters and hex pairs are decoded.
+func ParseDN(s string) (DN, error) {
+	dn := make(DN, 0)
+	if strings.TrimSpace(s) == "" {
+		return dn, nil
+	}
+
+	rdn := make(RDN, 0)
+	var ava AttributeTypeAndValue
+	var buf strings.Builder
+	// trailing spaces are insignificant unless escaped, lastSignificant marks the end of the value
+	lastSignificant := 0
+	inType := true
+	quoted := false
+
+	endAva := func() error {
+		if inType {
+			return fmt.Errorf("invalid dn %q: missing '='", s)
+		}
+		ava.Value = buf.String()[:lastSignificant]
+		if ava.Type == "" {
+			return fmt.Errorf("invalid dn %q: empty attribute type", s)
+		}
+		rdn = append(rdn, ava)
+		ava = AttributeTypeAndValue{}
+		buf.Reset()
+		lastSignificant = 0
+		inType = true
+		return nil
+	}
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case inType && c == '=':
+			ava.Type = strings.TrimSpace(buf.String())
+			buf.Reset()
+			inType = false
+			// skip leading whitespace of the value
+			for i+1 < len(s) && s[i+1] == ' ' {
+				i++
+			}
+			if i+1 < len(s) && s[i+1] == '"' {
+				quoted = true
+				i++
+			} else if i+1 < len(s) && s[i+1] == '#' {
+				// hex encoded BER value, kept as is
+				for i+1 < len(s) && s[i+1] != ',' && s[i+1] != '+' && s[i+1] != ';' && s[i+1] != ' ' {
+					i++
+					buf.WriteByte(s[i])
+				}
+				lastSignificant = buf.Len()
+			}
+		case inType && (c == ',' || c == '+' || c == ';'):
+			return nil, fmt.Errorf("invalid dn %q: missing '='", s)
+		case inType:
+			buf.WriteByte(c)
+		case quoted && c == '"':
+			quoted = false
+			lastSignificant = buf.Len()
+		case c == '\\':
+			if i+1 >= len(s) {
+				return nil, fmt.Errorf("invalid dn %q: trailing backslash", s)
+			} else if isHex(s[i+1]) && i+2 < len(s) && isHex(s[i+2]) {
+				b, _ := hex.DecodeString(s[i+1 : i+3])
+				buf.Write(b)
+				i += 2
+			} else {
+				buf.WriteByte(s[i+1])
+				i++
+			}
+			lastSignificant = buf.Len()
+		case quoted:
+			buf.WriteByte(c)
+			lastSignificant = buf.Len()
+		case c == '+':
+			if err := endAva(); err != nil {
+				return nil, err
+			}
+		case c == ',' || c == ';':
+			if err := endAva(); err != nil {
+				return nil, err
+			}
+			dn = append(dn, rdn)
+			rdn = make(RDN, 0)
+		case c == ' ':
+			buf.WriteByte(c)
+		case c == '"' || c == '<' || c == '>':
+			return nil, fmt.Errorf("invalid dn %q: unescaped '%c'", s, c)
+		default:
+			buf.WriteByte(c)
+			lastSignificant = buf.Len()
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("invalid dn %q: unterminated quote", s)
+	}
+	if err := endAva(); err != nil {
+		return nil, err
+	}
+	return append(dn, rdn), nil
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// EscapeDNValue escapes a value for use in the string representation of a DN.
+func EscapeDNValue(v string) string {
+	var buf strings.Builder
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		switch {
+		case c == ',' || c == '+' || c == '"' || c == '\\' || c == '<' || c == '>' || c == ';' || c == '=':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case c == 0:
+			buf.WriteString("\\00")
+		case (c == ' ' || c == '#') && i == 0:
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case c == ' ' && i == len(v)-1:
+			buf.WriteString("\\ ")
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String()
+}
+
+func (ava AttributeTypeAndValue) String() string {
+	return fmt.Sprintf("%s=%s", ava.Type, EscapeDNValue(ava.Value))
+}
+
+func (ava AttributeTypeAndValue) Equal(o AttributeTypeAndValue) bool {
+	return strings.EqualFold(ava.Type, o.Type) && strings.EqualFold(ava.Value, o.Value)
+}
+
+func (rdn RDN) String() string {
+	avas := make([]string, len(rdn))
+	for i, ava := range rdn {
+		avas[i] = ava.String()
+	}
+	return strings.Join(avas, "+")
+}
+
+// Equal compares types and values case-insensitively, the order of multiple values is insignificant.
+func (rdn RDN) Equal(o RDN) bool {
+	if len(rdn) != len(o) {
+		return false
+	}
+	for _, ava := range rdn {
+		found := false
+		for _, other := range o {
+			if ava.Equal(other) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Single returns type and value of a single-valued RDN.
+func (rdn RDN) Single() (string, string, bool) {
+	if len(rdn) != 1 {
+		return "", "", false
+	}
+	return rdn[0].Type, rdn[0].Value, true
+}
+
+// String returns the normalized string representation of the DN.
+func (dn DN) String() string {
+	rdns := make([]string, len(dn))
+	for i, rdn := range dn {
+		rdns[i] = rdn.String()
+	}
+	return strings.Join(rdns, ",")
+}
+
+func (dn DN) Equal(o DN) bool {
+	if len(dn) != len(o) {
+		return false
+	}
+	for i := range dn {
+		if !dn[i].Equal(o[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsDescendantOf checks whether the DN is below the base DN.
+func (dn DN) IsDescendantOf(base DN) bool {
+	return len(dn) > len(base) && dn[len(dn)-len(base):].Equal(base)
+}
+
+// equalDns compares two DNs in string representation, invalid DNs are never equal.
+func equalDns(a, b string) bool {
+	if dnA, err := ParseDN(a); err != nil {
+		return false
+	} else if dnB, err := ParseDN(b); err != nil {
+		return false
+	} else {
+		return dnA.Equal(dnB)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDN(t *testing.T) {
+	cases := map[string]DN{
+		"": {},
+		"cn=kevin,ou=people,dc=example,dc=com": {
+			{{"cn", "kevin"}}, {{"ou", "people"}}, {{"dc", "example"}}, {{"dc", "com"}},
+		},
+		" CN = kevin , OU=people ":  {{{"CN", "kevin"}}, {{"OU", "people"}}},
+		`cn=Doe\, John,dc=com`:      {{{"cn", "Doe, John"}}, {{"dc", "com"}}},
+		`cn=a\2Bb\\c\"d,dc=com`:     {{{"cn", `a+b\c"d`}}, {{"dc", "com"}}},
+		`cn=trailing\ ,dc=com`:      {{{"cn", "trailing "}}, {{"dc", "com"}}},
+		`cn="quoted, value";dc=com`: {{{"cn", "quoted, value"}}, {{"dc", "com"}}},
+		"cn=kevin+uid=k1,dc=com":    {{{"cn", "kevin"}, {"uid", "k1"}}, {{"dc", "com"}}},
+		"cn=#04024869,dc=com":       {{{"cn", "#04024869"}}, {{"dc", "com"}}},
+		"cn=a=b,dc=com":             {{{"cn", "a=b"}}, {{"dc", "com"}}},
+		"cn=J\xc3\xbcrgen,dc=com":   {{{"cn", "Jürgen"}}, {{"dc", "com"}}},
+		`cn=J\C3\BCrgen,dc=com`:     {{{"cn", "Jürgen"}}, {{"dc", "com"}}},
+	}
+
+	for s, expected := range cases {
+		dn, err := ParseDN(s)
+		assert.NoError(t, err, "for '%s'", s)
+		assert.Equal(t, expected, dn, "for '%s'", s)
+	}
+
+	for _, s := range []string{"kevin", "cn=kevin,", "=kevin", `cn=kevin\`, `cn="kevin`, "cn=<kevin>", "cn=a,,dc=com"} {
+		_, err := ParseDN(s)
+		assert.Error(t, err, "for '%s'", s)
+	}
+}
+
+func TestDN_String(t *testing.T) {
+	cases := map[string]string{
+		" CN = kevin , OU=people ":   "CN=kevin,OU=people",
+		`cn=Doe\2C John,dc=com`:      `cn=Doe\, John,dc=com`,
+		`cn=\ a\ ,dc=com`:            `cn=\ a\ ,dc=com`,
+		`cn=\#a+sn=x\+y;dc=com`:      `cn=\#a+sn=x\+y,dc=com`,
+		`cn=a\<b\>c\;d\=e\\f,dc=com`: `cn=a\<b\>c\;d\=e\\f,dc=com`,
+	}
+
+	for s, expected := range cases {
+		dn, err := ParseDN(s)
+		assert.NoError(t, err, "for '%s'", s)
+		assert.Equal(t, expected, dn.String(), "for '%s'", s)
+	}
+}
+
+func TestDN_Equal(t *testing.T) {
+	assert.True(t, equalDns("ou=people,dc=example,dc=com", "OU=People, DC=Example, DC=com"))
+	assert.True(t, equalDns("cn=a+sn=b,dc=com", "sn=b+cn=a,dc=com"))
+	assert.True(t, equalDns(`cn=Doe\, John,dc=com`, `cn=Doe\2C John,dc=com`))
+	assert.False(t, equalDns("ou=people,dc=example,dc=com", "ou=groups,dc=example,dc=com"))
+	assert.False(t, equalDns("ou=people,dc=example,dc=com", "dc=example,dc=com"))
+	assert.False(t, equalDns("ou=people", "invalid"))
+}
+
+func TestDN_IsDescendantOf(t *testing.T) {
+	base, _ := ParseDN("dc=example,dc=com")
+	for s, expected := range map[string]bool{
+		"cn=kevin,ou=people,dc=example,dc=com": true,
+		"ou=people,DC=Example,DC=com":          true,
+		"dc=example,dc=com":                    false,
+		"cn=kevin,dc=example,dc=org":           false,
+		"cn=kevin,dc=example.com":              false,
+	} {
+		dn, _ := ParseDN(s)
+		assert.Equal(t, expected, dn.IsDescendantOf(base), "for '%s'", s)
+	}
+}
+
+func TestName2dn(t *testing.T) {
+	assert.Equal(t, `cn=Doe\, John,ou=people,dc=com`, name2dn("cn", "ou=people,dc=com", "Doe, John"))
+
+	name, ok := dn2name("cn", "dc=com", name2dn("cn", "ou=people,dc=com", `a+b,c"d\`))
+	assert.True(t, ok)
+	assert.Equal(t, `a+b,c"d\`, name)
+
+	_, ok = dn2name("cn", "dc=example.com", "cn=kevin,dc=exampleXcom")
+	assert.False(t, ok)
+	_, ok = dn2name("cn", "dc=com", "uid=kevin,dc=com")
+	assert.False(t, ok)
+	_, ok = dn2name("cn", "dc=com", "dc=com")
+	assert.False(t, ok)
+}
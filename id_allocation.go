@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	IdAllocationNone = "none"
+	IdAllocationHash = "hash"
+	IdAllocationFile = "file"
+)
+
+type idRange struct {
+	first, last int
+}
+
+// ParseIdRange parses ranges in form "10000-59999".
+func ParseIdRange(s string) (idRange, error) {
+	parts := strings.SplitN(s, "-", 2)
+	if len(parts) != 2 {
+		return idRange{}, fmt.Errorf("invalid id range %q, expected form first-last", s)
+	} else if first, err := strconv.Atoi(parts[0]); err != nil {
+		return idRange{}, fmt.Errorf("invalid id range %q: %s", s, err.Error())
+	} else if last, err := strconv.Atoi(parts[1]); err != nil {
+		return idRange{}, fmt.Errorf("invalid id range %q: %s", s, err.Error())
+	} else if first < 0 || last < first {
+		return idRange{}, fmt.Errorf("invalid id range %q", s)
+	} else {
+		return idRange{first: first, last: last}, nil
+	}
+}
+
+func (r idRange) size() int {
+	return r.last - r.first + 1
+}
+
+type idAllocations struct {
+	Users  map[string]int `json:"users"`
+	Groups map[string]int `json:"groups"`
+}
+
+// idAllocator checks explicitly set uidNumbers and gidNumbers for collisions
+// and allocates numbers for users and groups without them.
+// With method hash, numbers are derived from names and stay stable across hosts unless hashes collide.
+// Colliding names are probed for a free number, which depends only on the names in the snapshot.
+// With method file, numbers are taken from an allocation file, new ones are allocated sequentially and added to it.
+// Backends share the allocator, reloads are processed one at a time.
+type idAllocator struct {
+	sync.Mutex
+	method   string
+	uidRange idRange
+	gidRange idRange
+	file     string
+	// readOnly allocates without writing the allocation file, e.g. to check the config
+	readOnly bool
+}
+
+func NewIdAllocator(method string, uidRange, gidRange idRange, file string) (*idAllocator, error) {
+	switch method {
+	case IdAllocationHash:
+	case IdAllocationFile:
+		if file == "" {
+			return nil, fmt.Errorf("id allocation method file needs an allocation file")
+		}
+	default:
+		return nil, fmt.Errorf("unknown id allocation method %q", method)
+	}
+	return &idAllocator{method: method, uidRange: uidRange, gidRange: gidRange, file: file}, nil
+}
+
+func (a *idAllocator) Process(usersByName map[string]*User, groupsByName map[string]*Group) error {
+	a.Lock()
+	defer a.Unlock()
+
+	userAttrs := make(map[string]map[string][]string, len(usersByName))
+	for name, u := range usersByName {
+		if u.Attr == nil {
+			u.Attr = make(map[string][]string)
+		}
+		userAttrs[name] = u.Attr
+	}
+	groupAttrs := make(map[string]map[string][]string, len(groupsByName))
+	for name, g := range groupsByName {
+		if g.Attr == nil {
+			g.Attr = make(map[string][]string)
+		}
+		groupAttrs[name] = g.Attr
+	}
+
+	allocations := &idAllocations{Users: map[string]int{}, Groups: map[string]int{}}
+	if a.method == IdAllocationFile {
+		if err := a.readAllocations(allocations); err != nil {
+			return err
+		}
+	}
+
+	changedUsers, err := allocateIds("uidNumber", userAttrs, a.uidRange, allocations.Users, a.method)
+	if err != nil {
+		return err
+	}
+	changedGroups, err := allocateIds("gidNumber", groupAttrs, a.gidRange, allocations.Groups, a.method)
+	if err != nil {
+		return err
+	}
+	if a.method == IdAllocationFile && !a.readOnly && (changedUsers || changedGroups) {
+		return a.writeAllocations(allocations)
+	}
+	return nil
+}
+
+// allocateIds sets attr for all entries missing it. Allocations are kept in and added to allocated.
+func allocateIds(attr string, entries map[string]map[string][]string, r idRange, allocated map[string]int, method string) (bool, error) {
+	used := make(map[int]string)
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if values, ok := entries[name][attr]; ok {
+			if len(values) != 1 {
+				return false, fmt.Errorf("%s of %s must have exactly one value", attr, name)
+			} else if id, err := strconv.Atoi(values[0]); err != nil {
+				return false, fmt.Errorf("invalid %s %q of %s", attr, values[0], name)
+			} else if other, ok := used[id]; ok {
+				return false, fmt.Errorf("%s %d is used by %s and %s", attr, id, other, name)
+			} else {
+				used[id] = name
+			}
+		}
+	}
+
+	// keep previous allocations, never hand out numbers of removed entries again
+	missing := make([]string, 0)
+	for _, name := range names {
+		if _, ok := entries[name][attr]; ok {
+			continue
+		} else if id, ok := allocated[name]; ok && r.contains(id) && used[id] == "" {
+			used[id] = name
+			entries[name][attr] = []string{strconv.Itoa(id)}
+		} else {
+			missing = append(missing, name)
+		}
+	}
+	for name, id := range allocated {
+		if _, ok := entries[name]; !ok && used[id] == "" {
+			used[id] = name
+		}
+	}
+
+	// with hash, hand out the numbers derived from names first, so probing
+	// for colliding names never takes the number of another name
+	changed := false
+	if method == IdAllocationHash {
+		colliding := make([]string, 0)
+		for _, name := range missing {
+			if id := r.hash(name); used[id] == "" {
+				log.Debugf("allocated %s %d for %s", attr, id, name)
+				allocated[name] = id
+				changed = true
+				used[id] = name
+				entries[name][attr] = []string{strconv.Itoa(id)}
+			} else {
+				colliding = append(colliding, name)
+			}
+		}
+		missing = colliding
+	}
+	for _, name := range missing {
+		start := r.first
+		if method == IdAllocationHash {
+			start = r.hash(name)
+		}
+		id, ok := r.free(start, used)
+		if !ok {
+			return false, fmt.Errorf("no free %s left in range %d-%d for %s", attr, r.first, r.last, name)
+		} else if method == IdAllocationHash {
+			log.Warningf("%s %d of %s is taken by %s, allocated %d, set it explicitly to keep it stable", attr, start, name, used[start], id)
+		} else {
+			log.Debugf("allocated %s %d for %s", attr, id, name)
+		}
+		allocated[name] = id
+		changed = true
+		used[id] = name
+		entries[name][attr] = []string{strconv.Itoa(id)}
+	}
+	return changed, nil
+}
+
+// hash derives an id from name.
+func (r idRange) hash(name string) int {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return r.first + int(h.Sum32()%uint32(r.size()))
+}
+
+// checkIdCollisions reports uidNumbers and gidNumbers used by different users resp. groups,
+// allocators only see the entries of a single backend.
+func checkIdCollisions(backend Backender) error {
+	users, err := backend.Users("", "")
+	if err != nil {
+		return err
+	}
+	uids := make(map[string]string)
+	for _, user := range users {
+		for _, id := range user.Attr["uidNumber"] {
+			if other, ok := uids[id]; ok && other != user.Name {
+				return fmt.Errorf("uidNumber %s is used by %s and %s", id, other, user.Name)
+			}
+			uids[id] = user.Name
+		}
+	}
+
+	groups, err := backend.Groups("", "")
+	if err != nil {
+		return err
+	}
+	gids := make(map[string]string)
+	for _, group := range groups {
+		for _, id := range group.Attr["gidNumber"] {
+			if other, ok := gids[id]; ok && other != group.Name {
+				return fmt.Errorf("gidNumber %s is used by %s and %s", id, other, group.Name)
+			}
+			gids[id] = group.Name
+		}
+	}
+	return nil
+}
+
+func (r idRange) contains(id int) bool {
+	return id >= r.first && id <= r.last
+}
+
+// free returns the first id not in use, starting at start and wrapping around at the end of the range.
+func (r idRange) free(start int, used map[int]string) (int, bool) {
+	for i := 0; i < r.size(); i++ {
+		id := r.first + (start-r.first+i)%r.size()
+		if _, ok := used[id]; !ok {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+func (a *idAllocator) readAllocations(allocations *idAllocations) error {
+	if content, err := ioutil.ReadFile(a.file); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	} else if err := json.Unmarshal(content, allocations); err != nil {
+		return fmt.Errorf("error reading id allocation file %s: %s", a.file, err.Error())
+	}
+	if allocations.Users == nil {
+		allocations.Users = map[string]int{}
+	}
+	if allocations.Groups == nil {
+		allocations.Groups = map[string]int{}
+	}
+	return nil
+}
+
+// writeAllocations replaces the allocation file atomically.
+func (a *idAllocator) writeAllocations(allocations *idAllocations) error {
+	content, err := json.MarshalIndent(allocations, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(a.file, content)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestIdData() (map[string]*User, map[string]*Group) {
+	return map[string]*User{
+		"u1": {Name: "u1", Attr: map[string][]string{"uidNumber": {"100"}}},
+		"u2": {Name: "u2"},
+		"u3": {Name: "u3", Attr: map[string][]string{"mail": {"u3@example.org"}}},
+	}, map[string]*Group{
+		"g1": {Name: "g1", Attr: map[string][]string{"gidNumber": {"101"}}},
+		"g2": {Name: "g2"},
+	}
+}
+
+func TestParseIdRange(t *testing.T) {
+	r, err := ParseIdRange("100-199")
+	assert.NoError(t, err)
+	assert.Equal(t, idRange{100, 199}, r)
+	assert.Equal(t, 100, r.size())
+
+	for _, s := range []string{"", "100", "a-b", "200-100", "-1-100"} {
+		_, err := ParseIdRange(s)
+		assert.Error(t, err, "for '%s'", s)
+	}
+}
+
+func TestIdAllocator_hash(t *testing.T) {
+	a, err := NewIdAllocator(IdAllocationHash, idRange{100, 102}, idRange{100, 101}, "")
+	assert.NoError(t, err)
+
+	users, groups := newTestIdData()
+	assert.NoError(t, a.Process(users, groups))
+	assert.Equal(t, []string{"100"}, users["u1"].Attr["uidNumber"])
+	assert.ElementsMatch(t, []string{"101", "102"}, append(users["u2"].Attr["uidNumber"], users["u3"].Attr["uidNumber"]...))
+	assert.Equal(t, []string{"u3@example.org"}, users["u3"].Attr["mail"])
+	assert.Equal(t, []string{"100"}, groups["g2"].Attr["gidNumber"])
+
+	// deterministic
+	users2, groups2 := newTestIdData()
+	assert.NoError(t, a.Process(users2, groups2))
+	assert.Equal(t, users["u2"].Attr, users2["u2"].Attr)
+
+	// range exhausted
+	users, groups = newTestIdData()
+	users["u4"] = &User{Name: "u4"}
+	assert.Error(t, a.Process(users, groups))
+}
+
+func TestIdAllocator_collision(t *testing.T) {
+	a, _ := NewIdAllocator(IdAllocationHash, idRange{100, 199}, idRange{100, 199}, "")
+
+	users, groups := newTestIdData()
+	users["u2"].Attr = map[string][]string{"uidNumber": {"100"}}
+	assert.Error(t, a.Process(users, groups))
+
+	users, groups = newTestIdData()
+	groups["g2"].Attr = map[string][]string{"gidNumber": {"101"}}
+	assert.Error(t, a.Process(users, groups))
+
+	users, groups = newTestIdData()
+	users["u2"].Attr = map[string][]string{"uidNumber": {"foo"}}
+	assert.Error(t, a.Process(users, groups))
+}
+
+func TestIdAllocator_file(t *testing.T) {
+	dir, _ := ioutil.TempDir(os.TempDir(), "aldapd-ids")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "ids.json")
+
+	_, err := NewIdAllocator(IdAllocationFile, idRange{100, 199}, idRange{100, 199}, "")
+	assert.Error(t, err)
+	a, err := NewIdAllocator(IdAllocationFile, idRange{100, 199}, idRange{100, 199}, file)
+	assert.NoError(t, err)
+
+	users, groups := newTestIdData()
+	assert.NoError(t, a.Process(users, groups))
+	assert.Equal(t, []string{"101"}, users["u2"].Attr["uidNumber"])
+	assert.Equal(t, []string{"102"}, users["u3"].Attr["uidNumber"])
+	assert.Equal(t, []string{"100"}, groups["g2"].Attr["gidNumber"])
+
+	// u2 got removed, its id is not reused
+	users, groups = newTestIdData()
+	delete(users, "u2")
+	users["u0"] = &User{Name: "u0"}
+	assert.NoError(t, a.Process(users, groups))
+	assert.Equal(t, []string{"103"}, users["u0"].Attr["uidNumber"])
+	assert.Equal(t, []string{"102"}, users["u3"].Attr["uidNumber"])
+
+	allocations := &idAllocations{}
+	assert.NoError(t, a.readAllocations(allocations))
+	assert.Equal(t, map[string]int{"u0": 103, "u2": 101, "u3": 102}, allocations.Users)
+}
+
+func TestNewLocalFileBackend_idCollision(t *testing.T) {
+	f, _ := ioutil.TempFile(os.TempDir(), "aldapd-config")
+	defer os.Remove(f.Name())
+	f.WriteString(`{"users":[{"name":"u1", "attr":{"uidNumber":["1"]}}, {"name":"u2", "attr":{"uidNumber":["1"]}}]}`)
+
+	a, _ := NewIdAllocator(IdAllocationHash, idRange{100, 199}, idRange{100, 199}, "")
+	_, err := NewLocalFileBackend([]string{f.Name()}, &SnapshotConfig{processors: []DataProcessor{a}})
+	assert.Error(t, err)
+}
+
+func TestIdAllocator_hashStable(t *testing.T) {
+	r := idRange{100, 109}
+	// find names colliding with x
+	colliding := make([]string, 0)
+	for i := 0; len(colliding) < 2; i++ {
+		if name := "a" + strconv.Itoa(i); r.hash(name) == r.hash("x") {
+			colliding = append(colliding, name)
+		}
+	}
+	// and a name taking the id probed for them
+	var removed string
+	for i := 0; removed == ""; i++ {
+		if name := "b" + strconv.Itoa(i); r.hash(name) == r.first+(r.hash("x")-r.first+1)%r.size() {
+			removed = name
+		}
+	}
+	newUsers := func() map[string]*User {
+		return map[string]*User{"x": {Name: "x"}, colliding[0]: {Name: colliding[0]}, colliding[1]: {Name: colliding[1]}}
+	}
+
+	// ids only depend on the snapshot, not on previous reloads
+	a, _ := NewIdAllocator(IdAllocationHash, r, r, "")
+	assert.NoError(t, a.Process(map[string]*User{"x": {Name: "x"}, removed: {Name: removed}}, map[string]*Group{}))
+	users := newUsers()
+	assert.NoError(t, a.Process(users, map[string]*Group{}))
+
+	other, _ := NewIdAllocator(IdAllocationHash, r, r, "")
+	otherUsers := newUsers()
+	assert.NoError(t, other.Process(otherUsers, map[string]*Group{}))
+	for name, user := range users {
+		assert.Equal(t, otherUsers[name].Attr["uidNumber"], user.Attr["uidNumber"], "for %s", name)
+	}
+}
+
+func TestIdAllocator_hashProbesLast(t *testing.T) {
+	r := idRange{100, 109}
+	// find a name colliding with x, and a name whose hash is the id x's collision probes to
+	var collision, next string
+	for i := 0; collision == "" || next == ""; i++ {
+		name := "a" + strconv.Itoa(i)
+		if r.hash(name) == r.hash("x") && collision == "" {
+			collision = name
+		} else if r.hash(name) == r.first+(r.hash("x")-r.first+1)%r.size() && next == "" {
+			next = name
+		}
+	}
+	a, _ := NewIdAllocator(IdAllocationHash, r, r, "")
+	users := map[string]*User{"x": {Name: "x"}, collision: {Name: collision}, next: {Name: next}}
+	assert.NoError(t, a.Process(users, map[string]*Group{}))
+	assert.Equal(t, []string{strconv.Itoa(r.hash(next))}, users[next].Attr["uidNumber"])
+}
+
+func TestIdAllocator_readOnly(t *testing.T) {
+	dir, _ := ioutil.TempDir(os.TempDir(), "aldapd-ids")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "ids.json")
+
+	a, _ := NewIdAllocator(IdAllocationFile, idRange{100, 199}, idRange{100, 199}, file)
+	a.readOnly = true
+	users, groups := newTestIdData()
+	assert.NoError(t, a.Process(users, groups))
+	assert.Equal(t, []string{"101"}, users["u2"].Attr["uidNumber"])
+	_, err := os.Stat(file)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCheckIdCollisions(t *testing.T) {
+	newBackend := func(content string) Backender {
+		f, _ := ioutil.TempFile(os.TempDir(), "aldapd-config")
+		defer os.Remove(f.Name())
+		f.WriteString(content)
+		b, err := NewLocalFileBackend([]string{f.Name()}, nil)
+		assert.NoError(t, err)
+		return b
+	}
+	first := newBackend(`{"users":[{"name":"u1", "attr":{"uidNumber":["1"]}}], "groups":[{"name":"g1", "attr":{"gidNumber":["1"]}}]}`)
+
+	// the same user in both backends
+	b, _ := NewCompositeBackend(PrecedenceMerge, first, newBackend(`{"users":[{"name":"u1", "attr":{"uidNumber":["1"]}}]}`))
+	assert.NoError(t, checkIdCollisions(b))
+
+	b, _ = NewCompositeBackend(PrecedenceMerge, first, newBackend(`{"users":[{"name":"u2", "attr":{"uidNumber":["1"]}}]}`))
+	assert.Error(t, checkIdCollisions(b))
+
+	b, _ = NewCompositeBackend(PrecedenceFirst, first, newBackend(`{"groups":[{"name":"g2", "attr":{"gidNumber":["1"]}}]}`))
+	assert.Error(t, checkIdCollisions(b))
+}
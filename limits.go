@@ -0,0 +1,148 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket per source IP.
+type RateLimiter struct {
+	sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*tokenBucket
+	now     func() time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter allows rate requests per second and bursts of burst requests.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// allow takes a token from the bucket of the source IP.
+func (r *RateLimiter) allow(ip string) bool {
+	r.Lock()
+	defer r.Unlock()
+	now := r.now()
+	if len(r.buckets) >= 1024 {
+		r.prune(now)
+	}
+	b, ok := r.buckets[ip]
+	if !ok {
+		b = &tokenBucket{tokens: r.burst, last: now}
+		r.buckets[ip] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * r.rate
+	if b.tokens > r.burst {
+		b.tokens = r.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// prune forgets buckets that were refilled, they are recreated full.
+func (r *RateLimiter) prune(now time.Time) {
+	for ip, b := range r.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*r.rate >= r.burst {
+			delete(r.buckets, ip)
+		}
+	}
+}
+
+// limitListener refuses connections above the overall and per IP limits, 0 is unlimited.
+type limitListener struct {
+	net.Listener
+	sync.Mutex
+	maxConns      int
+	maxConnsPerIP int
+	idleTimeout   time.Duration
+	conns         int
+	connsPerIP    map[string]int
+}
+
+func newLimitListener(ln net.Listener, c *Config) *limitListener {
+	return &limitListener{
+		Listener:      ln,
+		maxConns:      c.maxConns,
+		maxConnsPerIP: c.maxConnsPerIP,
+		idleTimeout:   c.idleTimeout,
+		connsPerIP:    make(map[string]int),
+	}
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		ip := remoteIP(conn)
+		if l.acquire(ip) {
+			return &limitConn{Conn: conn, listener: l, ip: ip}, nil
+		}
+		log.Warningf("refusing connection from %s, too many connections", ip)
+		conn.Close()
+	}
+}
+
+func (l *limitListener) acquire(ip string) bool {
+	l.Lock()
+	defer l.Unlock()
+	if l.maxConns > 0 && l.conns >= l.maxConns {
+		return false
+	} else if l.maxConnsPerIP > 0 && l.connsPerIP[ip] >= l.maxConnsPerIP {
+		return false
+	}
+	l.conns++
+	l.connsPerIP[ip]++
+	return true
+}
+
+func (l *limitListener) release(ip string) {
+	l.Lock()
+	defer l.Unlock()
+	l.conns--
+	if l.connsPerIP[ip]--; l.connsPerIP[ip] <= 0 {
+		delete(l.connsPerIP, ip)
+	}
+}
+
+// limitConn releases its slot once on Close and times out reads while idle.
+type limitConn struct {
+	net.Conn
+	listener *limitListener
+	ip       string
+	once     sync.Once
+}
+
+func (c *limitConn) Read(b []byte) (int, error) {
+	if c.listener.idleTimeout > 0 {
+		if err := c.Conn.SetReadDeadline(time.Now().Add(c.listener.idleTimeout)); err != nil {
+			return 0, err
+		}
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *limitConn) Close() error {
+	c.once.Do(func() { c.listener.release(c.ip) })
+	return c.Conn.Close()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mark-rushakoff/ldapserver"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := NewRateLimiter(2, 3)
+	r.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		assert.True(t, r.allow("10.0.0.1"))
+	}
+	assert.False(t, r.allow("10.0.0.1"))
+	assert.True(t, r.allow("10.0.0.2"))
+
+	now = now.Add(500 * time.Millisecond)
+	assert.True(t, r.allow("10.0.0.1"))
+	assert.False(t, r.allow("10.0.0.1"))
+
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		assert.True(t, r.allow("10.0.0.1"))
+	}
+	assert.False(t, r.allow("10.0.0.1"))
+
+	r.prune(now.Add(time.Hour))
+	assert.Empty(t, r.buckets)
+}
+
+func TestServer_search_rateLimit(t *testing.T) {
+	c := newTestConfig()
+	c.backend = newTestNameBackend()
+	c.searchLimiter = NewRateLimiter(0.001, 1)
+	s := NewServer(c)
+
+	req := ldapserver.SearchRequest{BaseDN: c.peopleDn, Filter: "(cn=u1)"}
+	result, _ := s.search("", req, nil)
+	assert.Equal(t, ldapserver.LDAPResultSuccess, result.ResultCode)
+	result, _ = s.search("", req, nil)
+	assert.Equal(t, ldapserver.LDAPResultBusy, result.ResultCode)
+
+	c.allowAnonBind = true
+	c.bindLimiter = NewRateLimiter(0.001, 1)
+	code, _ := s.bind("", "", nil)
+	assert.Equal(t, ldapserver.LDAPResultSuccess, code)
+	code, _ = s.bind("", "", nil)
+	assert.Equal(t, ldapserver.LDAPResultBusy, code)
+}
+
+func TestLimitListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+	c := newTestConfig()
+	c.maxConnsPerIP = 1
+	l := newLimitListener(ln, c)
+
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	first, _ := net.Dial("tcp", ln.Addr().String())
+	defer first.Close()
+	conn := <-accepted
+
+	// the second connection is closed right away
+	second, _ := net.Dial("tcp", ln.Addr().String())
+	defer second.Close()
+	second.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = second.Read(make([]byte, 1))
+	assert.Error(t, err)
+	l.Lock()
+	assert.Equal(t, 1, l.conns)
+	l.Unlock()
+
+	conn.Close()
+	conn.Close()
+	l.Lock()
+	assert.Equal(t, 0, l.conns)
+	assert.Empty(t, l.connsPerIP)
+	l.Unlock()
+
+	third, _ := net.Dial("tcp", ln.Addr().String())
+	defer third.Close()
+	(<-accepted).Close()
+}
+
+func TestLimitConn_idleTimeout(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	l := newLimitListener(nil, &Config{idleTimeout: 10 * time.Millisecond})
+	l.acquire("pipe")
+	conn := &limitConn{Conn: server, listener: l, ip: "pipe"}
+	defer conn.Close()
+
+	_, err := conn.Read(make([]byte, 1))
+	assert.Error(t, err)
+	assert.True(t, err.(net.Error).Timeout())
+}
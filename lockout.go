@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Lockout counts failed binds per user, or per user and source IP, and locks them out after too many.
+type Lockout struct {
+	sync.Mutex
+	threshold int
+	duration  time.Duration
+	perIP     bool
+	failures  map[string]*bindFailures
+	now       func() time.Time
+}
+
+type bindFailures struct {
+	count       int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// LockedOut is a currently locked out user, or user and source IP, as listed by the admin interface.
+type LockedOut struct {
+	Key         string    `json:"key"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// NewLockout locks out after threshold failed binds with less than duration between them, for duration.
+func NewLockout(threshold int, duration time.Duration, perIP bool) *Lockout {
+	return &Lockout{
+		threshold: threshold,
+		duration:  duration,
+		perIP:     perIP,
+		failures:  make(map[string]*bindFailures),
+		now:       time.Now,
+	}
+}
+
+// key identifies the counter of the user, including the source IP of the connection with perIP.
+func (l *Lockout) key(name string, conn net.Conn) string {
+	if ip := remoteIP(conn); l.perIP && ip != "" {
+		return name + "@" + ip
+	}
+	return name
+}
+
+func (l *Lockout) locked(key string) bool {
+	l.Lock()
+	defer l.Unlock()
+	f, ok := l.failures[key]
+	return ok && l.now().Before(f.lockedUntil)
+}
+
+// fail counts a failed bind and reports whether it locked out the key.
+func (l *Lockout) fail(key string) bool {
+	l.Lock()
+	defer l.Unlock()
+	now := l.now()
+	l.prune(now)
+	f, ok := l.failures[key]
+	if !ok {
+		f = &bindFailures{}
+		l.failures[key] = f
+	}
+	f.count++
+	f.last = now
+	if f.count >= l.threshold {
+		f.count = 0
+		f.lockedUntil = now.Add(l.duration)
+		log.Warningf("locking out %s until %s after %d failed binds", key, f.lockedUntil.Format(time.RFC3339), l.threshold)
+		return true
+	}
+	return false
+}
+
+func (l *Lockout) reset(key string) {
+	l.Lock()
+	defer l.Unlock()
+	delete(l.failures, key)
+}
+
+// prune forgets failures older than the lockout duration, keeping the map small during guessing attacks.
+func (l *Lockout) prune(now time.Time) {
+	for key, f := range l.failures {
+		if now.Sub(f.last) > l.duration && !now.Before(f.lockedUntil) {
+			delete(l.failures, key)
+		}
+	}
+}
+
+// lockedOut lists the keys currently locked out, sorted by key.
+func (l *Lockout) lockedOut() []LockedOut {
+	l.Lock()
+	defer l.Unlock()
+	now := l.now()
+	locked := make([]LockedOut, 0)
+	for key, f := range l.failures {
+		if now.Before(f.lockedUntil) {
+			locked = append(locked, LockedOut{Key: key, LockedUntil: f.lockedUntil})
+		}
+	}
+	sort.Slice(locked, func(i, j int) bool { return locked[i].Key < locked[j].Key })
+	return locked
+}
+
+// unlock lifts the lockout of the key and reports whether it was locked out.
+func (l *Lockout) unlock(key string) bool {
+	l.Lock()
+	defer l.Unlock()
+	f, ok := l.failures[key]
+	if ok {
+		delete(l.failures, key)
+		log.Infof("unlocked %s", key)
+	}
+	return ok && l.now().Before(f.lockedUntil)
+}
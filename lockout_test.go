@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mark-rushakoff/ldapserver"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestLockout(now *time.Time) *Lockout {
+	l := NewLockout(3, time.Minute, false)
+	l.now = func() time.Time { return *now }
+	return l
+}
+
+func TestLockout(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := newTestLockout(&now)
+
+	assert.False(t, l.fail("u1"))
+	assert.False(t, l.fail("u1"))
+	assert.False(t, l.locked("u1"))
+	assert.True(t, l.fail("u1"))
+	assert.True(t, l.locked("u1"))
+	assert.False(t, l.locked("u2"))
+	assert.Equal(t, []LockedOut{{Key: "u1", LockedUntil: now.Add(time.Minute)}}, l.lockedOut())
+
+	now = now.Add(time.Minute)
+	assert.False(t, l.locked("u1"))
+	assert.Empty(t, l.lockedOut())
+
+	// failures further apart than the duration are not counted
+	l.fail("u2")
+	l.fail("u2")
+	now = now.Add(2 * time.Minute)
+	assert.False(t, l.fail("u2"))
+	assert.Len(t, l.failures, 1)
+
+	l.reset("u2")
+	assert.False(t, l.fail("u2"))
+	assert.False(t, l.fail("u2"))
+	assert.True(t, l.fail("u2"))
+	assert.True(t, l.unlock("u2"))
+	assert.False(t, l.locked("u2"))
+	assert.False(t, l.unlock("u2"))
+}
+
+func TestLockout_key(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	assert.Equal(t, "u1", NewLockout(3, time.Minute, false).key("u1", server))
+	assert.Equal(t, "u1", NewLockout(3, time.Minute, true).key("u1", nil))
+	assert.Equal(t, "u1@pipe", NewLockout(3, time.Minute, true).key("u1", server))
+}
+
+func TestServer_bind_lockout(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	checks := 0
+	c := newTestConfig()
+	c.lockout = newTestLockout(&now)
+	c.backend = &TestBackend{bindFunc: func(username, password string) (bool, error) {
+		checks++
+		return password == "secret", nil
+	}}
+	s := NewServer(c)
+	dn := "cn=u1,ou=people,ou=test,dc=example,dc=com"
+
+	for i := 0; i < 3; i++ {
+		code, _ := s.bind(dn, "wrong", nil)
+		assert.Equal(t, ldapserver.LDAPResultInvalidCredentials, code)
+	}
+	code, _ := s.bind(dn, "secret", nil)
+	assert.Equal(t, ldapserver.LDAPResultInvalidCredentials, code)
+	assert.Equal(t, 3, checks)
+
+	now = now.Add(time.Minute)
+	code, _ = s.bind(dn, "secret", nil)
+	assert.Equal(t, ldapserver.LDAPResultSuccess, code)
+	assert.Equal(t, 4, checks)
+	assert.Empty(t, c.lockout.failures)
+}
+
+func TestServer_adminHandler(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := newTestConfig()
+	c.lockout = newTestLockout(&now)
+	for i := 0; i < 3; i++ {
+		c.lockout.fail("cn=u1,ou=people,ou=test,dc=example,dc=com")
+	}
+	h := NewServer(c).adminHandler()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/lockouts", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[{"key":"cn=u1,ou=people,ou=test,dc=example,dc=com","locked_until":"2020-01-01T00:01:00Z"}]`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/lockouts/cn=u1,ou=people,ou=test,dc=example,dc=com", nil))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.False(t, c.lockout.locked("cn=u1,ou=people,ou=test,dc=example,dc=com"))
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/lockouts/cn=u1,ou=people,ou=test,dc=example,dc=com", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAdminListener(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1:0", "localhost:0"} {
+		ln, err := adminListener(addr, false)
+		if assert.NoError(t, err, "for %s", addr) {
+			ln.Close()
+		}
+	}
+
+	_, err := adminListener(":0", false)
+	assert.Error(t, err)
+	ln, err := adminListener(":0", true)
+	if assert.NoError(t, err) {
+		ln.Close()
+	}
+}
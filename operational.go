@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mark-rushakoff/ldapserver"
+)
+
+const (
+	InactiveShow = "show"
+	InactiveMark = "mark"
+	InactiveHide = "hide"
+)
+
+// operationalAttrs are only returned when requested by name or with "+".
+// entryUUID, createTimestamp and modifyTimestamp may be set explicitly in the attributes of snapshot entries.
+var operationalAttrs = []string{"entryUUID", "createTimestamp", "modifyTimestamp", "entryDN", "hasSubordinates", "structuralObjectClass",
+	"nsAccountLock", "pwdAccountLockedTime", "pwdChangedTime"}
+
+// dnNamespace is the RFC 4122 name space for X.500 DNs.
+var dnNamespace = [16]byte{0x6b, 0xa7, 0xb8, 0x14, 0x9d, 0xad, 0x11, 0xd1, 0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8}
+
+// entryUUID derives a stable name based UUID (version 5) from the normalized DN.
+func entryUUID(dn string) string {
+	if parsed, err := ParseDN(dn); err == nil {
+		dn = strings.ToLower(parsed.String())
+	}
+	h := sha1.New()
+	h.Write(dnNamespace[:])
+	h.Write([]byte(dn))
+	u := h.Sum(nil)[:16]
+	u[6] = (u[6] & 0x0f) | 0x50
+	u[8] = (u[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+func generalizedTime(t time.Time) string {
+	return t.UTC().Format("20060102150405Z")
+}
+
+func isOperationalAttr(name string) bool {
+	for _, a := range operationalAttrs {
+		if strings.EqualFold(a, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// lockAttrs marks inactive accounts as locked for 389 Directory Server and password policy aware clients,
+// pwdAccountLockedTime 000001010000Z locks until an administrator unlocks.
+func lockAttrs(attr map[string][]string) {
+	attr["nsAccountLock"] = []string{"TRUE"}
+	attr["pwdAccountLockedTime"] = []string{"000001010000Z"}
+}
+
+// appendOperationalAttrs adds the operational attributes of an entry, preferring values set in the snapshot.
+func appendOperationalAttrs(attr []*ldapserver.EntryAttribute, dn string, values map[string][]string, structural string, createdAt, modifiedAt time.Time) []*ldapserver.EntryAttribute {
+	defaults := map[string][]string{
+		"entryUUID":             {entryUUID(dn)},
+		"entryDN":               {dn},
+		"hasSubordinates":       {"FALSE"},
+		"structuralObjectClass": {structural},
+	}
+	if !createdAt.IsZero() {
+		defaults["createTimestamp"] = []string{generalizedTime(createdAt)}
+	}
+	if !modifiedAt.IsZero() {
+		defaults["modifyTimestamp"] = []string{generalizedTime(modifiedAt)}
+	}
+	for _, name := range operationalAttrs {
+		if v := attrValues(values, name); len(v) > 0 && name != "entryDN" && name != "hasSubordinates" {
+			attr = appendAttr(attr, name, v...)
+		} else {
+			attr = appendAttr(attr, name, defaults[name]...)
+		}
+	}
+	return attr
+}
+
+// selectAttributes reduces the attributes of the entry to the requested ones.
+// No requested attributes or "*" select all user attributes, "+" selects all operational attributes.
+func selectAttributes(entry *ldapserver.Entry, requested []string) *ldapserver.Entry {
+	allUser := len(requested) == 0 || contains(requested, "*")
+	allOperational := contains(requested, "+")
+	attr := make([]*ldapserver.EntryAttribute, 0, len(entry.Attributes))
+	for _, a := range entry.Attributes {
+		operational := isOperationalAttr(a.Name)
+		if (allUser && !operational) || (allOperational && operational) || containsFold(requested, a.Name) {
+			attr = append(attr, a)
+		}
+	}
+	return &ldapserver.Entry{DN: entry.DN, Attributes: attr}
+}
+
+func containsFold(arr []string, s string) bool {
+	for _, e := range arr {
+		if strings.EqualFold(e, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mark-rushakoff/ldapserver"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEntryUUID(t *testing.T) {
+	uuid := entryUUID("cn=u1,ou=people,dc=example,dc=com")
+	assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-5[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, uuid)
+	assert.Equal(t, uuid, entryUUID("CN=u1, ou=People,dc=example,dc=com"))
+	assert.NotEqual(t, uuid, entryUUID("cn=u2,ou=people,dc=example,dc=com"))
+}
+
+func TestUser2entry_operational(t *testing.T) {
+	u := newTestUser("u1")
+	u.CreatedAt = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	u.ModifiedAt = time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	entry := user2entry(&u, newTestConfig())
+	assert.Equal(t, entryUUID(entry.DN), entry.GetAttributeValue("entryUUID"))
+	assert.Equal(t, "20200102030405Z", entry.GetAttributeValue("createTimestamp"))
+	assert.Equal(t, "20210102030405Z", entry.GetAttributeValue("modifyTimestamp"))
+	assert.Equal(t, entry.DN, entry.GetAttributeValue("entryDN"))
+	assert.Equal(t, "FALSE", entry.GetAttributeValue("hasSubordinates"))
+	assert.Equal(t, "inetOrgPerson", entry.GetAttributeValue("structuralObjectClass"))
+
+	u.Attr = map[string][]string{"entryUUID": {"d2b1f0ad-3c4e-4f56-9a8b-1c2d3e4f5a6b"}}
+	entry = user2entry(&u, newTestConfig())
+	assert.Equal(t, []string{"d2b1f0ad-3c4e-4f56-9a8b-1c2d3e4f5a6b"}, entry.GetAttributeValues("entryUUID"))
+}
+
+func TestSelectAttributes(t *testing.T) {
+	entry := &ldapserver.Entry{DN: "cn=u1", Attributes: []*ldapserver.EntryAttribute{
+		{Name: "cn", Values: []string{"u1"}},
+		{Name: "mail", Values: []string{"u1@example.org"}},
+		{Name: "entryUUID", Values: []string{"uuid"}},
+		{Name: "modifyTimestamp", Values: []string{"20200102030405Z"}},
+	}}
+	names := func(e *ldapserver.Entry) []string {
+		n := make([]string, 0)
+		for _, a := range e.Attributes {
+			n = append(n, a.Name)
+		}
+		return n
+	}
+
+	assert.Equal(t, []string{"cn", "mail"}, names(selectAttributes(entry, nil)))
+	assert.Equal(t, []string{"cn", "mail"}, names(selectAttributes(entry, []string{"*"})))
+	assert.Equal(t, []string{"entryUUID", "modifyTimestamp"}, names(selectAttributes(entry, []string{"+"})))
+	assert.Equal(t, []string{"cn", "mail", "entryUUID", "modifyTimestamp"}, names(selectAttributes(entry, []string{"*", "+"})))
+	assert.Equal(t, []string{"mail", "entryUUID"}, names(selectAttributes(entry, []string{"Mail", "entryuuid"})))
+	assert.Equal(t, []string{}, names(selectAttributes(entry, []string{"1.1"})))
+}
+
+func TestLocalFileBackend_timestamps(t *testing.T) {
+	f, _ := ioutil.TempFile(os.TempDir(), "aldapd-config")
+	defer os.Remove(f.Name())
+	f.WriteString(`{"generated_at": "2020-01-02T03:04:05Z", "users": [{"name":"u1"}, {"name":"u2"}]}`)
+
+	b, err := NewLocalFileBackend([]string{f.Name()}, nil)
+	assert.NoError(t, err)
+	first := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	assert.Equal(t, first, b.usersByName["u1"].CreatedAt)
+	assert.Equal(t, first, b.usersByName["u1"].ModifiedAt)
+
+	ioutil.WriteFile(f.Name(), []byte(`{"generated_at": "2020-02-02T03:04:05Z", "users": [{"name":"u1"}, {"name":"u2", "attr":{"mail":["u2@example.org"]}}, {"name":"u3"}]}`), 0644)
+	assert.NoError(t, b.Reload())
+	second := time.Date(2020, 2, 2, 3, 4, 5, 0, time.UTC)
+	assert.Equal(t, first, b.usersByName["u1"].ModifiedAt)
+	assert.Equal(t, first, b.usersByName["u2"].CreatedAt)
+	assert.Equal(t, second, b.usersByName["u2"].ModifiedAt)
+	assert.Equal(t, second, b.usersByName["u3"].CreatedAt)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// PasswordChanger is implemented by backends accepting password changes.
+// The password is already hashed.
+type PasswordChanger interface {
+	ChangePassword(username, password string) error
+}
+
+type passwordChange struct {
+	Name     string    `json:"name"`
+	Password string    `json:"password"`
+	Time     time.Time `json:"time"`
+}
+
+// passwordJournal records password changes in an append-only file and/or forwards them to an HTTP endpoint.
+// Recorded changes overlay snapshots generated before the change.
+type passwordJournal struct {
+	sync.Mutex
+	file     string
+	endpoint string
+	client   *http.Client
+	changes  map[string]passwordChange
+}
+
+// NewPasswordJournal reads the changes recorded in the journal file, which may not exist yet.
+func NewPasswordJournal(file, endpoint string) (*passwordJournal, error) {
+	j := &passwordJournal{
+		file:     file,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		changes:  make(map[string]passwordChange),
+	}
+	if file == "" {
+		return j, nil
+	}
+
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return j, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for i := 1; scanner.Scan(); i++ {
+		var change passwordChange
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		} else if err := json.Unmarshal(scanner.Bytes(), &change); err != nil {
+			return nil, fmt.Errorf("error parsing password journal %s line %d: %s", file, i, err.Error())
+		} else {
+			j.changes[change.Name] = change
+		}
+	}
+	log.Infof("loaded %d password changes from %s", len(j.changes), file)
+	return j, scanner.Err()
+}
+
+// record forwards and persists a password change before it takes effect.
+func (j *passwordJournal) record(name, password string) error {
+	j.Lock()
+	defer j.Unlock()
+
+	change := passwordChange{Name: name, Password: password, Time: time.Now().UTC()}
+	line, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	if j.endpoint != "" {
+		if err := j.forward(line); err != nil {
+			return err
+		}
+	}
+	if j.file != "" {
+		if err := j.append(line); err != nil {
+			return err
+		}
+	}
+	j.changes[name] = change
+	return nil
+}
+
+func (j *passwordJournal) forward(body []byte) error {
+	resp, err := j.client.Post(j.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error forwarding password change: %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("error forwarding password change: %s", resp.Status)
+	}
+	return nil
+}
+
+func (j *passwordJournal) append(line []byte) error {
+	f, err := os.OpenFile(j.file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// overlay replaces the passwords of users changed after the snapshot was generated.
+// Snapshots without generation time never supersede recorded changes.
+func (j *passwordJournal) overlay(usersByName map[string]*User, generatedAt time.Time) {
+	j.Lock()
+	defer j.Unlock()
+
+	for name, change := range j.changes {
+		if user, ok := usersByName[name]; !ok {
+			continue
+		} else if !generatedAt.IsZero() && !change.Time.After(generatedAt) {
+			log.Debugf("password change of user %s is superseded by snapshot", name)
+		} else {
+			log.Debugf("applying password change of user %s from %s", name, change.Time.Format(time.RFC3339))
+			user.Password = change.Password
+			changedAt := change.Time
+			user.PasswordChangedAt = &changedAt
+		}
+	}
+}
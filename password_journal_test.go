@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordJournal(t *testing.T) {
+	dir, _ := ioutil.TempDir(os.TempDir(), "aldapd-journal")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "journal")
+
+	j, err := NewPasswordJournal(file, "")
+	assert.NoError(t, err)
+	assert.NoError(t, j.record("u1", "hash1"))
+	assert.NoError(t, j.record("u2", "hash2"))
+	assert.NoError(t, j.record("u1", "hash3"))
+
+	j, err = NewPasswordJournal(file, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(j.changes))
+	assert.Equal(t, "hash3", j.changes["u1"].Password)
+
+	users := map[string]*User{"u1": {Name: "u1", Password: "old"}, "u2": {Name: "u2", Password: "old"}}
+	j.overlay(users, time.Time{})
+	assert.Equal(t, "hash3", users["u1"].Password)
+	assert.Equal(t, "hash2", users["u2"].Password)
+
+	users = map[string]*User{"u1": {Name: "u1", Password: "old"}}
+	j.overlay(users, j.changes["u1"].Time.Add(-time.Minute))
+	assert.Equal(t, "hash3", users["u1"].Password)
+
+	users = map[string]*User{"u1": {Name: "u1", Password: "new"}}
+	j.overlay(users, j.changes["u1"].Time.Add(time.Minute))
+	assert.Equal(t, "new", users["u1"].Password)
+
+	ioutil.WriteFile(file, []byte("not json\n"), 0600)
+	_, err = NewPasswordJournal(file, "")
+	assert.Error(t, err)
+}
+
+func TestPasswordJournal_endpoint(t *testing.T) {
+	var received passwordChange
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	j, err := NewPasswordJournal("", server.URL)
+	assert.NoError(t, err)
+	assert.NoError(t, j.record("u1", "hash1"))
+	assert.Equal(t, "u1", received.Name)
+	assert.Equal(t, "hash1", received.Password)
+	assert.Equal(t, "hash1", j.changes["u1"].Password)
+
+	status = http.StatusInternalServerError
+	assert.Error(t, j.record("u1", "hash2"))
+	assert.Equal(t, "hash1", j.changes["u1"].Password)
+}
+
+func TestLocalFileBackend_ChangePassword(t *testing.T) {
+	f, _ := ioutil.TempFile(os.TempDir(), "aldapd-config")
+	defer os.Remove(f.Name())
+	f.WriteString(validTestConfig)
+	journal, _ := ioutil.TempFile(os.TempDir(), "aldapd-journal")
+	defer os.Remove(journal.Name())
+
+	b, err := NewLocalFileBackend([]string{f.Name()}, nil)
+	assert.NoError(t, err)
+	assert.Error(t, b.ChangePassword("u1", "hash"))
+
+	j, _ := NewPasswordJournal(journal.Name(), "")
+	b, err = NewLocalFileBackend([]string{f.Name()}, &SnapshotConfig{journal: j})
+	assert.NoError(t, err)
+	hash, _ := hashPassword("new-password")
+	assert.NoError(t, b.ChangePassword("u1", hash))
+	assert.Error(t, b.ChangePassword("unknown", hash))
+
+	ok, _ := b.Check("u1", "new-password")
+	assert.True(t, ok)
+	ok, _ = b.Check("u1", "some-password")
+	assert.False(t, ok)
+
+	j, _ = NewPasswordJournal(journal.Name(), "")
+	b, err = NewLocalFileBackend([]string{f.Name()}, &SnapshotConfig{journal: j})
+	assert.NoError(t, err)
+	ok, _ = b.Check("u1", "new-password")
+	assert.True(t, ok)
+}
+
+func TestLocalFileBackend_ChangePassword_write(t *testing.T) {
+	f, _ := ioutil.TempFile(os.TempDir(), "aldapd-config")
+	defer os.Remove(f.Name())
+	f.WriteString(validTestConfig)
+	journal, _ := ioutil.TempFile(os.TempDir(), "aldapd-journal")
+	defer os.Remove(journal.Name())
+
+	j, _ := NewPasswordJournal(journal.Name(), "")
+	b, err := NewLocalFileBackend([]string{f.Name()}, &SnapshotConfig{journal: j, writable: true})
+	assert.NoError(t, err)
+	hash, _ := hashPassword("new-password")
+	assert.NoError(t, b.ChangePassword("u1", hash))
+	// the change happened well before the write
+	change := j.changes["u1"]
+	change.Time = change.Time.Add(-time.Hour)
+	j.changes["u1"] = change
+
+	// unrelated writes keep the changed password
+	assert.NoError(t, b.Write(func(data *BackendData) error {
+		data.Groups = append(data.Groups, &Group{Name: "g-new"})
+		return nil
+	}))
+	ok, _ := b.Check("u1", "new-password")
+	assert.True(t, ok)
+
+	var data BackendData
+	content, _ := ioutil.ReadFile(f.Name())
+	assert.NoError(t, json.Unmarshal(content, &data))
+	assert.Equal(t, hash, data.Users[0].Password)
+}
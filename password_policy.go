@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PasswordPolicy lets passwords expire maxAge after they were changed.
+// Binds with expired passwords succeed graceLogins times before they fail.
+// Used grace logins are kept in file if set, so restarts don't grant new ones.
+// The draft-behera password policy response control is not implemented,
+// bind results of ldapserver only carry a result code.
+type PasswordPolicy struct {
+	sync.Mutex
+	maxAge        time.Duration
+	expireWarning time.Duration
+	graceLogins   int
+	graceUsed     map[string]int
+	file          string
+	now           func() time.Time
+}
+
+func NewPasswordPolicy(maxAge, expireWarning time.Duration, graceLogins int, file string) (*PasswordPolicy, error) {
+	p := &PasswordPolicy{
+		maxAge:        maxAge,
+		expireWarning: expireWarning,
+		graceLogins:   graceLogins,
+		graceUsed:     make(map[string]int),
+		file:          file,
+		now:           time.Now,
+	}
+	if file == "" {
+		return p, nil
+	} else if content, err := ioutil.ReadFile(file); os.IsNotExist(err) {
+		return p, nil
+	} else if err != nil {
+		return nil, err
+	} else if err := json.Unmarshal(content, &p.graceUsed); err != nil {
+		return nil, fmt.Errorf("error reading grace login file %s: %s", file, err.Error())
+	}
+	return p, nil
+}
+
+// evaluate checks the password age of a user who just bound successfully and reports whether the bind may succeed.
+// Users without passwordChangedAt never expire.
+func (p *PasswordPolicy) evaluate(user *User) bool {
+	maxAge := p.maxAge
+	if user.MaxAge > 0 {
+		maxAge = time.Duration(user.MaxAge) * time.Second
+	}
+	if maxAge <= 0 || user.PasswordChangedAt == nil {
+		return true
+	}
+
+	now := p.now()
+	expiresAt := user.PasswordChangedAt.Add(maxAge)
+	if now.Before(expiresAt) {
+		if left := expiresAt.Sub(now); left <= p.expireWarning {
+			log.Infof("password of user %s expires in %s", user.Name, left.Truncate(time.Second))
+		}
+		return true
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	// grace logins are counted per password, a new password starts over
+	key := fmt.Sprintf("%s@%d", user.Name, user.PasswordChangedAt.Unix())
+	if used := p.graceUsed[key]; used < p.graceLogins {
+		// forget grace logins of previous passwords
+		for k := range p.graceUsed {
+			if i := strings.LastIndex(k, "@"); i >= 0 && k[:i] == user.Name {
+				delete(p.graceUsed, k)
+			}
+		}
+		p.graceUsed[key] = used + 1
+		log.Warningf("password of user %s expired, %d grace logins remaining", user.Name, p.graceLogins-used-1)
+		if err := p.writeGraceUsed(); err != nil {
+			log.Errorf("error writing grace login file %s: %s", p.file, err.Error())
+		}
+		return true
+	}
+	log.Warningf("refusing bind of user %s, password expired at %s", user.Name, expiresAt.Format(time.RFC3339))
+	return false
+}
+
+// writeGraceUsed replaces the grace login file atomically.
+func (p *PasswordPolicy) writeGraceUsed() error {
+	if p.file == "" {
+		return nil
+	}
+	content, err := json.MarshalIndent(p.graceUsed, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(p.file, content)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mark-rushakoff/ldapserver"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestPasswordPolicy(now *time.Time) *PasswordPolicy {
+	p, _ := NewPasswordPolicy(30*24*time.Hour, 7*24*time.Hour, 2, "")
+	p.now = func() time.Time { return *now }
+	return p
+}
+
+func TestPasswordPolicy_evaluate(t *testing.T) {
+	changedAt := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := changedAt
+	p := newTestPasswordPolicy(&now)
+	user := &User{Name: "u1", PasswordChangedAt: &changedAt}
+
+	assert.True(t, p.evaluate(user))
+	now = changedAt.Add(29 * 24 * time.Hour)
+	assert.True(t, p.evaluate(user))
+
+	now = changedAt.Add(30 * 24 * time.Hour)
+	assert.True(t, p.evaluate(user))
+	assert.True(t, p.evaluate(user))
+	assert.False(t, p.evaluate(user))
+
+	// a new password starts over
+	changedAgain := changedAt.Add(-time.Hour)
+	assert.True(t, p.evaluate(&User{Name: "u1", PasswordChangedAt: &changedAgain}))
+	assert.Len(t, p.graceUsed, 1)
+
+	// the user's maxAge overrides the global one
+	user.MaxAge = 60 * 24 * 60 * 60
+	assert.True(t, p.evaluate(user))
+
+	// passwords without change time never expire
+	assert.True(t, p.evaluate(&User{Name: "u2"}))
+}
+
+func TestPasswordPolicy_graceFile(t *testing.T) {
+	dir, _ := ioutil.TempDir(os.TempDir(), "aldapd-grace")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "grace.json")
+	changedAt := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := changedAt.Add(31 * 24 * time.Hour)
+	user := &User{Name: "u1", PasswordChangedAt: &changedAt}
+
+	p, err := NewPasswordPolicy(30*24*time.Hour, 0, 1, file)
+	assert.NoError(t, err)
+	p.now = func() time.Time { return now }
+	assert.True(t, p.evaluate(user))
+
+	// restarts don't grant new grace logins
+	p, err = NewPasswordPolicy(30*24*time.Hour, 0, 1, file)
+	assert.NoError(t, err)
+	p.now = func() time.Time { return now }
+	assert.False(t, p.evaluate(user))
+
+	ioutil.WriteFile(file, []byte("invalid"), 0600)
+	_, err = NewPasswordPolicy(30*24*time.Hour, 0, 1, file)
+	assert.Error(t, err)
+}
+
+func TestServer_bind_passwordExpired(t *testing.T) {
+	changedAt := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := changedAt.Add(31 * 24 * time.Hour)
+	c := newTestConfig()
+	c.ppolicy = newTestPasswordPolicy(&now)
+	c.ppolicy.graceLogins = 1
+	c.backend = &TestBackend{
+		bindFunc: func(username, password string) (bool, error) {
+			return password == "secret", nil
+		},
+		usersFunc: func(filterKey, filterValue string) ([]User, error) {
+			return []User{{Name: "u1", PasswordChangedAt: &changedAt}}, nil
+		},
+	}
+	s := NewServer(c)
+	dn := "cn=u1,ou=people,ou=test,dc=example,dc=com"
+
+	code, _ := s.bind(dn, "secret", nil)
+	assert.Equal(t, ldapserver.LDAPResultSuccess, code)
+	code, _ = s.bind(dn, "secret", nil)
+	assert.Equal(t, ldapserver.LDAPResultInvalidCredentials, code)
+	_, ok := s.sessions.user(nil)
+	assert.False(t, ok)
+}
+
+func TestUser2entry_pwdChangedTime(t *testing.T) {
+	changedAt := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	user := newTestUser("u1")
+	user.PasswordChangedAt = &changedAt
+	c := newTestConfig()
+
+	entry := selectAttributes(user2entry(&user, c), []string{"+"})
+	assert.Equal(t, []string{"20200101120000Z"}, entry.GetAttributeValues("pwdChangedTime"))
+	entry = selectAttributes(user2entry(&user, c), []string{"*"})
+	assert.Empty(t, entry.GetAttributeValues("pwdChangedTime"))
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	apr1Magic  = "$apr1$"
+	apr1Itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+)
+
+func checkPassword(username, password, storedPassword string) (bool, error) {
+	switch {
+	case len(storedPassword) == 38 && strings.HasPrefix(storedPassword, "{SSHA}"):
+		return checkPasswordSSHA(username, password, storedPassword)
+	case strings.HasPrefix(storedPassword, "{SHA}"):
+		return checkPasswordSHA(username, password, storedPassword)
+	case strings.HasPrefix(storedPassword, apr1Magic):
+		return checkPasswordAPR1(password, storedPassword), nil
+	case strings.HasPrefix(storedPassword, "$2a$"),
+		strings.HasPrefix(storedPassword, "$2b$"),
+		strings.HasPrefix(storedPassword, "$2y$"):
+		return checkPasswordBcrypt(password, storedPassword), nil
+	default:
+		log.Warningf("unknown password hash method for user %s", username)
+		return false, nil
+	}
+}
+
+func checkPasswordSSHA(username, password, storedPassword string) (bool, error) {
+	if byts, err := base64.StdEncoding.DecodeString(storedPassword[6:]); err != nil {
+		log.Errorf("error decoding password for user %s", username)
+		return false, err
+	} else {
+		salt := byts[20:]
+		hash := byts[:20]
+		check := sha1.Sum(append([]byte(password), salt...))
+		return bytes.Equal(hash, check[:]), nil
+	}
+}
+
+func checkPasswordSHA(username, password, storedPassword string) (bool, error) {
+	if hash, err := base64.StdEncoding.DecodeString(storedPassword[5:]); err != nil {
+		log.Errorf("error decoding password for user %s", username)
+		return false, err
+	} else {
+		check := sha1.Sum([]byte(password))
+		return bytes.Equal(hash, check[:]), nil
+	}
+}
+
+func checkPasswordBcrypt(password, storedPassword string) bool {
+	// htpasswd writes $2y$, which is the same algorithm as $2a$
+	if strings.HasPrefix(storedPassword, "$2y$") {
+		storedPassword = "$2a$" + storedPassword[4:]
+	}
+	return bcrypt.CompareHashAndPassword([]byte(storedPassword), []byte(password)) == nil
+}
+
+func checkPasswordAPR1(password, storedPassword string) bool {
+	salt := strings.SplitN(storedPassword[len(apr1Magic):], "$", 2)[0]
+	check := apr1(password, salt)
+	return subtle.ConstantTimeCompare([]byte(check), []byte(storedPassword)) == 1
+}
+
+// apr1 implements apache's variant of the md5 based crypt algorithm.
+func apr1(password, salt string) string {
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw := []byte(password)
+
+	alt := md5.New()
+	alt.Write(pw)
+	alt.Write([]byte(salt))
+	alt.Write(pw)
+	altSum := alt.Sum(nil)
+
+	ctx := md5.New()
+	ctx.Write(pw)
+	ctx.Write([]byte(apr1Magic))
+	ctx.Write([]byte(salt))
+	for i := len(pw); i > 0; i -= 16 {
+		if i > 16 {
+			ctx.Write(altSum)
+		} else {
+			ctx.Write(altSum[:i])
+		}
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			ctx.Write([]byte{0})
+		} else {
+			ctx.Write(pw[:1])
+		}
+	}
+	final := ctx.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		round := md5.New()
+		if i&1 != 0 {
+			round.Write(pw)
+		} else {
+			round.Write(final)
+		}
+		if i%3 != 0 {
+			round.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			round.Write(pw)
+		}
+		if i&1 != 0 {
+			round.Write(final)
+		} else {
+			round.Write(pw)
+		}
+		final = round.Sum(nil)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(apr1Magic)
+	buf.WriteString(salt)
+	buf.WriteByte('$')
+	for _, i := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		v := uint(final[i[0]])<<16 | uint(final[i[1]])<<8 | uint(final[i[2]])
+		for n := 0; n < 4; n++ {
+			buf.WriteByte(apr1Itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+	v := uint(final[11])
+	for n := 0; n < 2; n++ {
+		buf.WriteByte(apr1Itoa64[v&0x3f])
+		v >>= 6
+	}
+	return buf.String()
+}
+
+// isPasswordHash reports whether the password is hashed with one of the supported methods.
+func isPasswordHash(password string) bool {
+	for _, prefix := range []string{"{SSHA}", "{SHA}", apr1Magic, "$2a$", "$2b$", "$2y$"} {
+		if strings.HasPrefix(password, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// hashPassword hashes new passwords with bcrypt.
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// generatePassword returns a random password for password modify requests without a new password.
+func generatePassword() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
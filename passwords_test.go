@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckPassword(t *testing.T) {
+	cases := []string{
+		"{SSHA}hNsogC9IKy6CFkQzyDSMPmOlAnxcc27o",
+		"{SHA}C+7Hteo/D9vJXQ3UfzxbwnXaijM=",
+		"$apr1$abcdefgh$awk9jawvrNMt/NFJ.cswm.",
+		"$2a$05$2Z.suBX6RQ2pm0hQC7UpK.MsIFX206hRASVrz2IqQf.tRe3Q8Ln0a",
+		"$2y$05$2Z.suBX6RQ2pm0hQC7UpK.MsIFX206hRASVrz2IqQf.tRe3Q8Ln0a",
+	}
+
+	for _, c := range cases {
+		r, err := checkPassword("u1", "foo", c)
+		assert.NoError(t, err)
+		assert.True(t, r, "for '%s'", c)
+
+		r, err = checkPassword("u1", "something-other", c)
+		assert.NoError(t, err)
+		assert.False(t, r, "for '%s'", c)
+	}
+}
+
+func TestCheckPassword_unknown(t *testing.T) {
+	r, err := checkPassword("u1", "foo", "foo")
+	assert.NoError(t, err)
+	assert.False(t, r)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"math/big"
+	"strings"
+)
+
+// Equality matching rules of attribute types as defined by RFC 4517.
+const (
+	CaseIgnoreMatch = "caseIgnoreMatch"
+	CaseExactMatch  = "caseExactMatch"
+	IntegerMatch    = "integerMatch"
+
+	DistinguishedNameMatch = "distinguishedNameMatch"
+)
+
+// AttributeType describes how values of an attribute are compared in search filters
+// and whether entries may have more than one value.
+type AttributeType struct {
+	Name        string
+	Equality    string
+	SingleValue bool
+}
+
+// attributeTypes lists the attributes aldapd knows, others are compared with caseIgnoreMatch.
+// Name is the canonical spelling filter keys are normalized to.
+var attributeTypes = []AttributeType{
+	{"cn", CaseIgnoreMatch, false},
+	{"sn", CaseIgnoreMatch, false},
+	{"givenName", CaseIgnoreMatch, false},
+	{"displayName", CaseIgnoreMatch, true},
+	{"description", CaseIgnoreMatch, false},
+	{"mail", CaseIgnoreMatch, false},
+	{"userPrincipalName", CaseIgnoreMatch, false},
+	{"uid", CaseIgnoreMatch, false},
+	{"ou", CaseIgnoreMatch, false},
+	{"title", CaseIgnoreMatch, false},
+	{"telephoneNumber", CaseIgnoreMatch, false},
+	{"employeeNumber", CaseIgnoreMatch, true},
+	{"objectClass", CaseIgnoreMatch, false},
+	{"member", DistinguishedNameMatch, false},
+	{"memberOf", DistinguishedNameMatch, false},
+	{"entryDN", DistinguishedNameMatch, true},
+	{"entryUUID", CaseIgnoreMatch, true},
+	{"uidNumber", IntegerMatch, true},
+	{"gidNumber", IntegerMatch, true},
+	{"homeDirectory", CaseExactMatch, true},
+	{"loginShell", CaseExactMatch, true},
+	{"gecos", CaseIgnoreMatch, true},
+	{"memberUid", CaseExactMatch, false},
+	{"sshPublicKey", CaseExactMatch, false},
+	{"userPassword", CaseExactMatch, false},
+}
+
+// attributeType returns the description of the attribute, names are case-insensitive.
+func attributeType(name string) AttributeType {
+	for _, t := range attributeTypes {
+		if strings.EqualFold(t.Name, name) {
+			return t
+		}
+	}
+	return AttributeType{Name: name, Equality: CaseIgnoreMatch}
+}
+
+// Match compares an assertion value with an attribute value using the equality matching rule.
+// Values not parsing as integers never match with integerMatch.
+func (t AttributeType) Match(value, assertion string) bool {
+	switch t.Equality {
+	case CaseExactMatch:
+		return normalizeSpace(value) == normalizeSpace(assertion)
+	case DistinguishedNameMatch:
+		return equalDns(value, assertion)
+	case IntegerMatch:
+		a, ok := new(big.Int).SetString(strings.TrimSpace(value), 10)
+		b, okB := new(big.Int).SetString(strings.TrimSpace(assertion), 10)
+		return ok && okB && a.Cmp(b) == 0
+	default:
+		return strings.EqualFold(normalizeSpace(value), normalizeSpace(assertion))
+	}
+}
+
+// MatchAny reports whether any of the values matches the assertion value.
+func (t AttributeType) MatchAny(values []string, assertion string) bool {
+	for _, v := range values {
+		if t.Match(v, assertion) {
+			return true
+		}
+	}
+	return false
+}
+
+// attrValues looks up the values of an attribute, names are case-insensitive.
+func attrValues(attr map[string][]string, name string) []string {
+	if values, ok := attr[name]; ok {
+		return values
+	}
+	for k, values := range attr {
+		if strings.EqualFold(k, name) {
+			return values
+		}
+	}
+	return nil
+}
+
+// normalizeSpace trims and collapses inner whitespace as string matching rules ignore insignificant spaces.
+func normalizeSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAttributeType(t *testing.T) {
+	assert.Equal(t, AttributeType{"memberOf", DistinguishedNameMatch, false}, attributeType("MEMBEROF"))
+	assert.Equal(t, AttributeType{"uidNumber", IntegerMatch, true}, attributeType("uidnumber"))
+	assert.Equal(t, AttributeType{"homeDirectory", CaseExactMatch, true}, attributeType("homeDirectory"))
+	assert.Equal(t, AttributeType{"fooBar", CaseIgnoreMatch, false}, attributeType("fooBar"))
+}
+
+func TestAttributeType_Match(t *testing.T) {
+	mail := attributeType("mail")
+	assert.True(t, mail.Match("kevin@example.org", "Kevin@Example.ORG"))
+	assert.True(t, attributeType("cn").Match("Kevin  Smith", " kevin smith "))
+	assert.False(t, mail.Match("kevin@example.org", "kevin@example.com"))
+
+	home := attributeType("homeDirectory")
+	assert.True(t, home.Match("/home/kevin", "/home/kevin"))
+	assert.False(t, home.Match("/home/kevin", "/home/Kevin"))
+
+	uidNumber := attributeType("uidNumber")
+	assert.True(t, uidNumber.Match("1001", "01001"))
+	assert.True(t, uidNumber.Match("-5", " -5"))
+	assert.False(t, uidNumber.Match("1001", "1002"))
+	assert.False(t, uidNumber.Match("1001", "abc"))
+	assert.False(t, uidNumber.Match("abc", "abc"))
+
+	memberOf := attributeType("memberOf")
+	assert.True(t, memberOf.Match("cn=g1,ou=groups,dc=com", "CN=G1, ou=Groups,dc=com"))
+	assert.False(t, memberOf.Match("cn=g1,ou=groups,dc=com", "cn=g2,ou=groups,dc=com"))
+
+	assert.True(t, mail.MatchAny([]string{"a@example.org", "b@example.org"}, "B@example.org"))
+	assert.False(t, mail.MatchAny(nil, "b@example.org"))
+}
+
+func TestAttrValues(t *testing.T) {
+	attr := map[string][]string{"mail": {"a@example.org"}, "Foo": {"bar"}}
+	assert.Equal(t, []string{"a@example.org"}, attrValues(attr, "mail"))
+	assert.Equal(t, []string{"a@example.org"}, attrValues(attr, "MAIL"))
+	assert.Equal(t, []string{"bar"}, attrValues(attr, "foo"))
+	assert.Nil(t, attrValues(attr, "missing"))
+}
@@ -2,9 +2,11 @@ package main
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/mark-rushakoff/ldapserver"
 	"github.com/op/go-logging"
@@ -17,17 +19,53 @@ var (
 type Config struct {
 	listenAddr    string
 	listenPort    uint32
+	maxConns      int
+	maxConnsPerIP int
+	idleTimeout   time.Duration
+	bindLimiter   *RateLimiter
+	searchLimiter *RateLimiter
 	allowAnonBind bool
 	baseDn        string
 	peopleDn      string
 	groupsDn      string
+	servicesDn    string
+	userRdnAttr   string
+	groupRdnAttr  string
+	bindAttrs     []string
+	inactive      string
+	writers       []string
+	acl           *ACL
+	lockout       *Lockout
+	ppolicy       *PasswordPolicy
 	backend       Backender
 }
 
+func (c *Config) userDn(name string) string {
+	return name2dn(c.userRdnAttr, c.peopleDn, name)
+}
+
+func (c *Config) userDns(names []string) []string {
+	return names2dns(c.userRdnAttr, c.peopleDn, names)
+}
+
+func (c *Config) groupDn(name string) string {
+	return name2dn(c.groupRdnAttr, c.groupsDn, name)
+}
+
+func (c *Config) groupDns(names []string) []string {
+	return names2dns(c.groupRdnAttr, c.groupsDn, names)
+}
+
+// serviceDn always names service accounts by cn.
+func (c *Config) serviceDn(name string) string {
+	return name2dn("cn", c.servicesDn, name)
+}
+
 type Server struct {
 	config     *Config
 	backend    Backender
 	ldapServer *ldapserver.Server
+	sessions   *sessions
 }
 
 func NewServer(config *Config) *Server {
@@ -35,10 +73,18 @@ func NewServer(config *Config) *Server {
 		config:     config,
 		backend:    config.backend,
 		ldapServer: ldapserver.NewServer(),
+		sessions:   newSessions(),
 	}
 
 	s.ldapServer.Bind = s.bind
 	s.ldapServer.Search = s.search
+	s.ldapServer.Compare = s.compare
+	s.ldapServer.Extended = s.extended
+	s.ldapServer.Unbind = s.unbind
+	s.ldapServer.Add = s.add
+	s.ldapServer.Modify = s.modify
+	s.ldapServer.Delete = s.delete
+	s.ldapServer.ModifyDN = s.modifyDn
 
 	return s
 
@@ -53,10 +99,14 @@ func StartServer(config *Config) *Server {
 func (s *Server) ListenAndServe() error {
 	listen := fmt.Sprintf("%s:%d", s.config.listenAddr, s.config.listenPort)
 	log.Infof("starting example LDAP server on %s with base dn %s", listen, s.config.baseDn)
-	if err := s.ldapServer.ListenAndServe(listen); err != nil {
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
 		return err
 	}
-	return nil
+	if s.config.maxConns > 0 || s.config.maxConnsPerIP > 0 || s.config.idleTimeout > 0 {
+		ln = newLimitListener(ln, s.config)
+	}
+	return s.ldapServer.Serve(&sessionListener{Listener: ln, sessions: s.sessions})
 }
 
 func (s *Server) Reload() {
@@ -65,6 +115,14 @@ func (s *Server) Reload() {
 	}
 }
 
+func (s *Server) Rollback() {
+	if r, ok := s.backend.(RollBacker); !ok {
+		log.Error("backend does not support rollback")
+	} else if err := r.Rollback(); err != nil {
+		log.Errorf("error rolling back backend data: %s", err.Error())
+	}
+}
+
 func (s *Server) Close() {
 	log.Info("shutting down LDAP server")
 	s.ldapServer.Close()
@@ -81,6 +139,8 @@ func (s *Server) signalHandler() {
 	for sig := range c {
 		if sig == syscall.SIGTERM || sig == syscall.SIGINT {
 			s.Close()
+		} else if sig == syscall.SIGUSR2 {
+			s.Rollback()
 		} else {
 			s.Reload()
 		}
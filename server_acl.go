@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net"
+
+	"github.com/mark-rushakoff/ldapserver"
+)
+
+// subject returns the user or service account the connection is bound as.
+func (s *Server) subject(conn net.Conn) aclSubject {
+	if name, ok := s.sessions.service(conn); ok {
+		return aclSubject{name: name, dn: s.config.serviceDn(name), service: true}
+	}
+	username, _ := s.sessions.user(conn)
+	return s.subjectOf(username)
+}
+
+// subjectOf looks up the groups of the user, an empty name is anonymous.
+func (s *Server) subjectOf(username string) aclSubject {
+	if username == "" {
+		return aclSubject{}
+	}
+	subject := aclSubject{name: username, dn: s.config.userDn(username)}
+	if s.config.acl != nil {
+		if users, err := s.backend.Users("cn", username); err != nil {
+			log.Errorf("error looking up groups of %s: %s", username, err.Error())
+		} else if len(users) > 0 {
+			subject.groups = users[0].Groups
+		}
+	}
+	return subject
+}
+
+// allowed checks the access with the configured ACL.
+// Without ACL everybody may read and compare everything and only the configured writers may write.
+func (s *Server) allowed(subject aclSubject, dn, attr, access string) bool {
+	if s.config.acl != nil && s.config.acl.allowed(subject, dn, attr, access, s.config) {
+		return true
+	} else if access == AccessWrite {
+		for _, writer := range s.config.writers {
+			if subject.name != "" && equalDns(writer, subject.dn) {
+				return true
+			}
+		}
+		return false
+	}
+	return s.config.acl == nil
+}
+
+// readableEntries drops the attributes the subject may not read and entries without any readable attributes.
+// Entries are dropped as well if the subject may not read the attribute they were filtered by.
+func (s *Server) readableEntries(entries []*ldapserver.Entry, subject aclSubject, filterAttr string) []*ldapserver.Entry {
+	if s.config.acl == nil {
+		return entries
+	}
+	readable := make([]*ldapserver.Entry, 0, len(entries))
+	for _, entry := range entries {
+		if filterAttr != "" && !s.allowed(subject, entry.DN, filterAttr, AccessRead) {
+			continue
+		}
+		attr := make([]*ldapserver.EntryAttribute, 0, len(entry.Attributes))
+		for _, a := range entry.Attributes {
+			if s.allowed(subject, entry.DN, a.Name, AccessRead) {
+				attr = append(attr, a)
+			}
+		}
+		if len(attr) > 0 {
+			readable = append(readable, &ldapserver.Entry{DN: entry.DN, Attributes: attr})
+		}
+	}
+	return readable
+}
@@ -9,17 +9,152 @@ import (
 
 func (s *Server) bind(bindDn, bindSimplePw string, conn net.Conn) (ldapserver.LDAPResultCode, error) {
 	log.Debugf("bind request: bindDn=%s, bindSimplePw=%s", bindDn, redactNonEmpty(bindSimplePw))
-	if bindDn == "" && bindSimplePw == "" {
+	// every bind starts over as anonymous, failed binds included
+	s.sessions.forget(conn)
+	if s.config.bindLimiter != nil && !s.config.bindLimiter.allow(remoteIP(conn)) {
+		log.Warningf("refusing bind from %s, rate limit exceeded", remoteIP(conn))
+		return ldapserver.LDAPResultBusy, nil
+	} else if bindDn == "" && bindSimplePw == "" {
 		if s.config.allowAnonBind {
 			return ldapserver.LDAPResultSuccess, nil
 		} else {
 			return ldapserver.LDAPResultInvalidCredentials, nil
 		}
-	} else if username, ok := dn2cn(s.config.baseDn, strings.ToLower(bindDn)); !ok {
+	} else if name, ok := s.bindDn2service(bindDn); ok {
+		return s.checkBind(s.config.serviceDn(name), conn, func() (bool, error) {
+			return s.checkService(name, bindSimplePw)
+		}, func() ldapserver.LDAPResultCode {
+			s.sessions.bindService(conn, name)
+			return ldapserver.LDAPResultSuccess
+		})
+	} else if username, ok := s.bindDn2name(bindDn); !ok {
 		return ldapserver.LDAPResultInvalidCredentials, nil
-	} else if ok, err := s.backend.Check(username, bindSimplePw); !ok {
+	} else {
+		username = s.userName(username)
+		return s.checkBind(s.config.userDn(username), conn, func() (bool, error) {
+			return s.backend.Check(username, bindSimplePw)
+		}, func() ldapserver.LDAPResultCode {
+			if !s.passwordValid(username) {
+				return ldapserver.LDAPResultInvalidCredentials
+			}
+			s.sessions.bind(conn, username)
+			return ldapserver.LDAPResultSuccess
+		})
+	}
+}
+
+// checkBind checks the password unless the DN is locked out and counts failures with a configured lockout.
+// bound is called after a successful check and returns the result of the bind.
+func (s *Server) checkBind(dn string, conn net.Conn, check func() (bool, error), bound func() ldapserver.LDAPResultCode) (ldapserver.LDAPResultCode, error) {
+	lockout := s.config.lockout
+	var key string
+	if lockout != nil {
+		key = lockout.key(dn, conn)
+		if lockout.locked(key) {
+			log.Warningf("refusing bind of locked out %s", key)
+			return ldapserver.LDAPResultInvalidCredentials, nil
+		}
+	}
+	if ok, err := check(); !ok {
+		if lockout != nil && err == nil {
+			lockout.fail(key)
+		}
 		return ldapserver.LDAPResultInvalidCredentials, err
 	} else {
-		return ldapserver.LDAPResultSuccess, err
+		if lockout != nil {
+			lockout.reset(key)
+		}
+		return bound(), err
+	}
+}
+
+// passwordValid applies the password policy to a user who just bound successfully.
+func (s *Server) passwordValid(username string) bool {
+	if s.config.ppolicy == nil {
+		return true
+	}
+	users, err := s.backend.Users("cn", username)
+	if err != nil || len(users) == 0 {
+		return true
+	}
+	return s.config.ppolicy.evaluate(&users[0])
+}
+
+func (s *Server) unbind(boundDn string, conn net.Conn) (ldapserver.LDAPResultCode, error) {
+	s.sessions.forget(conn)
+	return ldapserver.LDAPResultSuccess, nil
+}
+
+// bindDn2service resolves the name of service accounts from DNs below the services DN.
+func (s *Server) bindDn2service(bindDn string) (string, bool) {
+	if s.config.servicesDn == "" {
+		return "", false
+	}
+	return childName(bindDn, "cn", s.config.servicesDn)
+}
+
+func (s *Server) checkService(name, password string) (bool, error) {
+	if sb, ok := s.backend.(ServiceBackender); !ok {
+		return false, nil
+	} else {
+		return sb.CheckService(name, password)
+	}
+}
+
+// bindDn2name resolves the user's name from DNs with the naming attribute
+// and from DNs or plain identifiers like mail addresses matching one of the configured bind attributes.
+func (s *Server) bindDn2name(bindDn string) (string, bool) {
+	if !strings.Contains(bindDn, "=") {
+		for _, attr := range s.config.bindAttrs {
+			if name, ok := s.lookupBindName(attr, bindDn); ok {
+				return name, true
+			}
+		}
+		log.Warningf("failed to resolve bind identifier %s to username", bindDn)
+		return "", false
+	}
+
+	dn, err := ParseDN(bindDn)
+	if err != nil {
+		log.Warningf("invalid bind dn: %s", err.Error())
+		return "", false
+	} else if base, err := ParseDN(s.config.baseDn); err != nil || !dn.IsDescendantOf(base) {
+		log.Warningf("bind dn=%s is not below base dn", bindDn)
+		return "", false
+	} else if attr, value, ok := dn[0].Single(); !ok {
+		log.Warningf("unsupported multi-valued rdn in bind dn=%s", bindDn)
+		return "", false
+	} else if strings.EqualFold(attr, s.config.userRdnAttr) {
+		return value, true
+	} else {
+		for _, bindAttr := range s.config.bindAttrs {
+			if strings.EqualFold(attr, bindAttr) {
+				return s.lookupBindName(bindAttr, value)
+			}
+		}
+		log.Warningf("unsupported bind dn=%s", bindDn)
+		return "", false
+	}
+}
+
+// userName returns the name as known to the backend, which resolves names case-insensitively,
+// so sessions and lockouts don't depend on the case used in the bind DN.
+func (s *Server) userName(name string) string {
+	if users, err := s.backend.Users("cn", name); err == nil && len(users) == 1 {
+		return users[0].Name
+	}
+	return name
+}
+
+func (s *Server) lookupBindName(attr, value string) (string, bool) {
+	if users, err := s.backend.Users(attr, value); err != nil {
+		log.Errorf("error looking up user by %s: %s", attr, err.Error())
+		return "", false
+	} else if len(users) == 1 {
+		log.Debugf("resolved %s=%s to user %s", attr, value, users[0].Name)
+		return users[0].Name, true
+	} else if len(users) > 1 {
+		log.Warningf("ambiguous bind identifier %s=%s matches %d users", attr, value, len(users))
 	}
+	return "", false
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/mark-rushakoff/ldapserver"
@@ -15,6 +16,12 @@ func TestServer_bind(t *testing.T) {
 	tb.bindFunc = func(username, password string) (bool, error) {
 		return username == password, nil
 	}
+	tb.usersFunc = func(filterKey, filterValue string) ([]User, error) {
+		if filterKey == "cn" && strings.EqualFold(filterValue, "foo") {
+			return []User{{Name: "foo"}}, nil
+		}
+		return nil, nil
+	}
 
 	conn, err := ldapserver.Dial("tcp", listen)
 	assert.NotNil(t, conn)
@@ -57,3 +64,84 @@ func TestServer_bind_error(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Error(t, conn.Bind("foo", "foo"))
 }
+
+func TestServer_bind_rdnAttr(t *testing.T) {
+	c := newTestConfig()
+	c.userRdnAttr = "uid"
+	c.backend = &TestBackend{bindFunc: func(username, password string) (bool, error) {
+		return username == password, nil
+	}}
+	s := NewServer(c)
+
+	r, err := s.bind("uid=foo,ou=people,ou=test,dc=example,dc=com", "foo", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, ldapserver.LDAPResultSuccess, r)
+
+	r, err = s.bind("cn=foo,ou=people,ou=test,dc=example,dc=com", "foo", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, ldapserver.LDAPResultInvalidCredentials, r)
+}
+
+func TestServer_bind_nameCase(t *testing.T) {
+	c := newTestConfig()
+	c.backend = &TestBackend{
+		bindFunc: func(username, password string) (bool, error) {
+			return username == "Foo" && password == "foo", nil
+		},
+		usersFunc: func(filterKey, filterValue string) ([]User, error) {
+			if filterKey == "cn" && strings.EqualFold(filterValue, "foo") {
+				return []User{newTestUser("Foo")}, nil
+			}
+			return nil, nil
+		},
+	}
+	s := NewServer(c)
+
+	name, ok := s.bindDn2name("cn=FOO,ou=people,ou=test,dc=example,dc=com")
+	assert.True(t, ok)
+	assert.Equal(t, "FOO", name)
+	assert.Equal(t, "Foo", s.userName(name))
+
+	r, err := s.bind("cn=foo,ou=people,ou=test,dc=example,dc=com", "foo", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, ldapserver.LDAPResultSuccess, r)
+}
+
+func TestServer_bind_alternateIdentifiers(t *testing.T) {
+	c := newTestConfig()
+	c.bindAttrs = []string{"mail", "userPrincipalName"}
+	c.backend = &TestBackend{
+		bindFunc: func(username, password string) (bool, error) {
+			return username == "u1" && password == "foo", nil
+		},
+		usersFunc: func(filterKey, filterValue string) ([]User, error) {
+			switch {
+			case filterKey == "mail" && filterValue == "u1@example.org":
+				return []User{newTestUser("u1")}, nil
+			case filterKey == "userPrincipalName" && filterValue == "u1@EXAMPLE":
+				return []User{newTestUser("u1")}, nil
+			case filterKey == "mail" && filterValue == "shared@example.org":
+				return []User{newTestUser("u1"), newTestUser("u2")}, nil
+			default:
+				return []User{}, nil
+			}
+		},
+	}
+	s := NewServer(c)
+
+	cases := map[string]ldapserver.LDAPResultCode{
+		"u1@example.org": ldapserver.LDAPResultSuccess,
+		"u1@EXAMPLE":     ldapserver.LDAPResultSuccess,
+		"mail=u1@example.org,ou=people,ou=test,dc=example,dc=com": ldapserver.LDAPResultSuccess,
+		"cn=u1,ou=people,ou=test,dc=example,dc=com":               ldapserver.LDAPResultSuccess,
+		"shared@example.org":                         ldapserver.LDAPResultInvalidCredentials,
+		"u2@example.org":                             ldapserver.LDAPResultInvalidCredentials,
+		"uid=u1,ou=people,ou=test,dc=example,dc=com": ldapserver.LDAPResultInvalidCredentials,
+		"mail=u1@example.org,dc=other":               ldapserver.LDAPResultInvalidCredentials,
+	}
+	for bindDn, expected := range cases {
+		r, err := s.bind(bindDn, "foo", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, r, "for '%s'", bindDn)
+	}
+}
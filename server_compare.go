@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net"
+	"strings"
+
+	"github.com/mark-rushakoff/ldapserver"
+)
+
+func (s *Server) compare(boundDn string, req ldapserver.CompareRequest, conn net.Conn) (ldapserver.LDAPResultCode, error) {
+	log.Debugf("compare request: bindDn=%s, dn=%s, attr=%s", boundDn, req.DN, req.Name)
+
+	if entry, ok, err := s.entryByDn(req.DN); err != nil {
+		log.Errorf("error getting entry from backend: %s", err.Error())
+		return ldapserver.LDAPResultOperationsError, err
+	} else if !ok {
+		return ldapserver.LDAPResultNoSuchObject, nil
+	} else if !s.allowed(s.subject(conn), entry.DN, attributeType(req.Name).Name, AccessCompare) {
+		return ldapserver.LDAPResultInsufficientAccessRights, nil
+	} else if values, ok := entryAttrValues(entry, req.Name); !ok {
+		return ldapserver.LDAPResultNoSuchAttribute, nil
+	} else if attributeType(req.Name).MatchAny(values, req.Value) {
+		return ldapserver.LDAPResultCompareTrue, nil
+	} else {
+		return ldapserver.LDAPResultCompareFalse, nil
+	}
+}
+
+// entryAttrValues looks up the values of an entry's attribute, names are case-insensitive.
+func entryAttrValues(entry *ldapserver.Entry, name string) ([]string, bool) {
+	for _, a := range entry.Attributes {
+		if strings.EqualFold(a.Name, name) {
+			return a.Values, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mark-rushakoff/ldapserver"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestNameBackend serves users u1 and u2 and group g1 by name.
+func newTestNameBackend() *TestBackend {
+	return &TestBackend{
+		usersFunc: func(filterKey, filterValue string) ([]User, error) {
+			if filterKey == "cn" && (filterValue == "u1" || filterValue == "u2") {
+				return []User{newTestUser(filterValue)}, nil
+			}
+			return []User{}, nil
+		},
+		groupsFunc: func(filterKey, filterValue string) ([]Group, error) {
+			if filterKey == "cn" && filterValue == "g1" {
+				return []Group{newTestGroup(filterValue)}, nil
+			}
+			return []Group{}, nil
+		},
+	}
+}
+
+func TestServer_compare(t *testing.T) {
+	c := newTestConfig()
+	c.backend = newTestNameBackend()
+	s := NewServer(c)
+
+	user := "cn=u1,ou=people,ou=test,dc=example,dc=com"
+	group := "cn=g1,ou=groups,ou=test,dc=example,dc=com"
+	cases := []struct {
+		dn, name, value string
+		expected        ldapserver.LDAPResultCode
+	}{
+		{user, "mail", "U1@example.org", ldapserver.LDAPResultCompareTrue},
+		{user, "Mail", "u2@example.org", ldapserver.LDAPResultCompareFalse},
+		{user, "objectClass", "inetOrgPerson", ldapserver.LDAPResultCompareTrue},
+		{user, "memberOf", "CN=g1,ou=groups,ou=test,dc=example,dc=com", ldapserver.LDAPResultCompareTrue},
+		{user, "memberOf", "cn=g4,ou=groups,ou=test,dc=example,dc=com", ldapserver.LDAPResultCompareFalse},
+		{user, "entryDN", user, ldapserver.LDAPResultCompareTrue},
+		{user, "telephoneNumber", "123", ldapserver.LDAPResultNoSuchAttribute},
+		{group, "member", "cn=u2,ou=people,ou=test,dc=example,dc=com", ldapserver.LDAPResultCompareTrue},
+		{group, "member", "cn=u4,ou=people,ou=test,dc=example,dc=com", ldapserver.LDAPResultCompareFalse},
+		{"cn=u4,ou=people,ou=test,dc=example,dc=com", "cn", "u4", ldapserver.LDAPResultNoSuchObject},
+		{"cn=u1,ou=other,ou=test,dc=example,dc=com", "cn", "u1", ldapserver.LDAPResultNoSuchObject},
+		{"cn=u1,ou=groups,ou=test,dc=example,dc=com", "cn", "u1", ldapserver.LDAPResultNoSuchObject},
+		{"invalid", "cn", "u1", ldapserver.LDAPResultNoSuchObject},
+	}
+	for _, tc := range cases {
+		code, err := s.compare("", ldapserver.CompareRequest{DN: tc.dn, Name: tc.name, Value: tc.value}, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, code, "for %s: %s=%s", tc.dn, tc.name, tc.value)
+	}
+}
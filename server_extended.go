@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/asn1"
+	"net"
+	"strings"
+
+	"github.com/mark-rushakoff/ldapserver"
+)
+
+const (
+	// PasswordModifyOID is the RFC 3062 Password Modify extended operation.
+	PasswordModifyOID = "1.3.6.1.4.1.4203.1.11.1"
+	// WhoAmIOID is the RFC 4532 Who am I? extended operation.
+	WhoAmIOID = "1.3.6.1.4.1.4203.1.11.3"
+)
+
+type passwordModifyRequest struct {
+	UserIdentity []byte `asn1:"optional,tag:0"`
+	OldPasswd    []byte `asn1:"optional,tag:1"`
+	NewPasswd    []byte `asn1:"optional,tag:2"`
+}
+
+type passwordModifyResponse struct {
+	GenPasswd []byte `asn1:"optional,tag:0"`
+}
+
+func (s *Server) extended(boundDn string, req ldapserver.ExtendedRequest, conn net.Conn) (ldapserver.ServerExtendedResult, error) {
+	log.Debugf("extended request: bindDn=%s, name=%s", boundDn, req.RequestName)
+
+	switch req.RequestName {
+	case PasswordModifyOID:
+		return s.passwordModify(req, conn)
+	case WhoAmIOID:
+		return s.whoAmI(conn)
+	default:
+		log.Warningf("unsupported extended operation %s", req.RequestName)
+		return ldapserver.ServerExtendedResult{ResultCode: ldapserver.LDAPResultProtocolError}, nil
+	}
+}
+
+// passwordModify lets bound users change their own password and those of users they may write userPassword of.
+// Anonymous users may change their own password with the user identity and old password,
+// users whose password expired can't bind anymore.
+// A new password is generated and returned if the request doesn't contain one.
+func (s *Server) passwordModify(req ldapserver.ExtendedRequest, conn net.Conn) (ldapserver.ServerExtendedResult, error) {
+	var pm passwordModifyRequest
+	if len(req.RequestValue) > 0 {
+		if _, err := asn1.Unmarshal(req.RequestValue, &pm); err != nil {
+			log.Warningf("invalid password modify request: %s", err.Error())
+			return ldapserver.ServerExtendedResult{ResultCode: ldapserver.LDAPResultProtocolError}, nil
+		}
+	}
+
+	changer, ok := s.backend.(PasswordChanger)
+	if !ok {
+		return ldapserver.ServerExtendedResult{ResultCode: ldapserver.LDAPResultUnwillingToPerform}, nil
+	}
+
+	// the caller is taken from the session, the bound DN doesn't tell users and service accounts apart
+	var bound string
+	if name, ok := s.sessions.service(conn); ok {
+		log.Warningf("refusing password change by service account %s", name)
+		return ldapserver.ServerExtendedResult{ResultCode: ldapserver.LDAPResultInsufficientAccessRights}, nil
+	} else if name, ok := s.sessions.user(conn); ok {
+		bound = name
+	} else if len(pm.UserIdentity) > 0 && len(pm.OldPasswd) > 0 {
+		var code ldapserver.LDAPResultCode
+		var err error
+		if bound, code, err = s.authenticate(string(pm.UserIdentity), string(pm.OldPasswd), conn); code != ldapserver.LDAPResultSuccess {
+			return ldapserver.ServerExtendedResult{ResultCode: code}, err
+		}
+	} else {
+		log.Warningf("refusing password change of anonymous user")
+		return ldapserver.ServerExtendedResult{ResultCode: ldapserver.LDAPResultInsufficientAccessRights}, nil
+	}
+	username := bound
+	if len(pm.UserIdentity) > 0 {
+		if username, ok = s.identity2name(string(pm.UserIdentity)); !ok {
+			return ldapserver.ServerExtendedResult{ResultCode: ldapserver.LDAPResultNoSuchObject}, nil
+		}
+	}
+	if username != bound && !s.allowed(s.subjectOf(bound), s.config.userDn(username), "userPassword", AccessWrite) {
+		log.Warningf("refusing password change of user %s by %s", username, bound)
+		return ldapserver.ServerExtendedResult{ResultCode: ldapserver.LDAPResultInsufficientAccessRights}, nil
+	}
+
+	if len(pm.OldPasswd) > 0 && username == bound {
+		if ok, err := s.backend.Check(username, string(pm.OldPasswd)); err != nil {
+			return ldapserver.ServerExtendedResult{ResultCode: ldapserver.LDAPResultOperationsError}, err
+		} else if !ok {
+			return ldapserver.ServerExtendedResult{ResultCode: ldapserver.LDAPResultInvalidCredentials}, nil
+		}
+	}
+
+	var resp passwordModifyResponse
+	password := string(pm.NewPasswd)
+	if password == "" {
+		if generated, err := generatePassword(); err != nil {
+			return ldapserver.ServerExtendedResult{ResultCode: ldapserver.LDAPResultOperationsError}, err
+		} else {
+			password = generated
+			resp.GenPasswd = []byte(generated)
+		}
+	}
+
+	if hash, err := hashPassword(password); err != nil {
+		return ldapserver.ServerExtendedResult{ResultCode: ldapserver.LDAPResultOperationsError}, err
+	} else if err := changer.ChangePassword(username, hash); err != nil {
+		log.Errorf("error changing password of user %s: %s", username, err.Error())
+		return ldapserver.ServerExtendedResult{ResultCode: ldapserver.LDAPResultUnwillingToPerform}, nil
+	}
+
+	result := ldapserver.ServerExtendedResult{ResultCode: ldapserver.LDAPResultSuccess}
+	if resp.GenPasswd != nil {
+		if value, err := asn1.Marshal(resp); err != nil {
+			return ldapserver.ServerExtendedResult{ResultCode: ldapserver.LDAPResultOperationsError}, err
+		} else {
+			result.ResponseValue = value
+		}
+	}
+	return result, nil
+}
+
+// authenticate checks the old password of anonymous password modify requests like a bind,
+// including rate limits and lockouts, but without applying the password policy.
+func (s *Server) authenticate(identity, password string, conn net.Conn) (string, ldapserver.LDAPResultCode, error) {
+	if s.config.bindLimiter != nil && !s.config.bindLimiter.allow(remoteIP(conn)) {
+		log.Warningf("refusing password change from %s, rate limit exceeded", remoteIP(conn))
+		return "", ldapserver.LDAPResultBusy, nil
+	}
+	username, ok := s.identity2name(identity)
+	if !ok {
+		return "", ldapserver.LDAPResultInvalidCredentials, nil
+	}
+	username = s.userName(username)
+	code, err := s.checkBind(s.config.userDn(username), conn, func() (bool, error) {
+		return s.backend.Check(username, password)
+	}, func() ldapserver.LDAPResultCode {
+		return ldapserver.LDAPResultSuccess
+	})
+	return username, code, err
+}
+
+// whoAmI returns the authorization identity of the connection, empty for anonymous connections.
+func (s *Server) whoAmI(conn net.Conn) (ldapserver.ServerExtendedResult, error) {
+	result := ldapserver.ServerExtendedResult{ResultCode: ldapserver.LDAPResultSuccess}
+	if username, ok := s.sessions.user(conn); ok {
+		result.ResponseValue = []byte("dn:" + s.config.userDn(username))
+	} else if name, ok := s.sessions.service(conn); ok {
+		result.ResponseValue = []byte("dn:" + s.config.serviceDn(name))
+	}
+	return result, nil
+}
+
+// identity2name resolves a DN or a plain user name.
+func (s *Server) identity2name(identity string) (string, bool) {
+	if !strings.Contains(identity, "=") {
+		if users, err := s.backend.Users("cn", identity); err == nil && len(users) == 1 {
+			return users[0].Name, true
+		}
+	}
+	return s.bindDn2name(identity)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/asn1"
+	"net"
+	"testing"
+
+	"github.com/mark-rushakoff/ldapserver"
+	"github.com/stretchr/testify/assert"
+)
+
+type testPasswordBackend struct {
+	*TestBackend
+	changed map[string]string
+}
+
+func (b *testPasswordBackend) ChangePassword(username, password string) error {
+	b.changed[username] = password
+	return nil
+}
+
+type testServicePasswordBackend struct {
+	*testPasswordBackend
+}
+
+func (b *testServicePasswordBackend) Services() ([]User, error) {
+	return []User{{Name: "u1"}}, nil
+}
+
+func (b *testServicePasswordBackend) CheckService(name, password string) (bool, error) {
+	return name == "u1" && password == "service", nil
+}
+
+func TestServer_extended_unsupported(t *testing.T) {
+	s := NewServer(newTestConfig())
+	result, err := s.extended("", ldapserver.ExtendedRequest{RequestName: "1.2.3"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, ldapserver.LDAPResultProtocolError, result.ResultCode)
+}
+
+func TestServer_passwordModify(t *testing.T) {
+	c := newTestConfig()
+	tb := newTestNameBackend()
+	tb.bindFunc = func(username, password string) (bool, error) {
+		return password == "old", nil
+	}
+	b := &testPasswordBackend{TestBackend: tb, changed: make(map[string]string)}
+	c.backend = b
+	s := NewServer(c)
+
+	modify := func(boundDn string, pm passwordModifyRequest) (ldapserver.ServerExtendedResult, error) {
+		s.sessions.forget(nil)
+		if name, ok := s.bindDn2name(boundDn); ok {
+			s.sessions.bind(nil, name)
+		}
+		value, _ := asn1.Marshal(pm)
+		return s.extended(boundDn, ldapserver.ExtendedRequest{RequestName: PasswordModifyOID, RequestValue: value}, nil)
+	}
+	u1 := "cn=u1,ou=people,ou=test,dc=example,dc=com"
+
+	result, err := modify(u1, passwordModifyRequest{OldPasswd: []byte("old"), NewPasswd: []byte("new")})
+	assert.NoError(t, err)
+	assert.Equal(t, ldapserver.LDAPResultSuccess, result.ResultCode)
+	assert.Nil(t, result.ResponseValue)
+	ok, _ := checkPassword("u1", "new", b.changed["u1"])
+	assert.True(t, ok)
+
+	result, err = modify(u1, passwordModifyRequest{UserIdentity: []byte("u1")})
+	assert.NoError(t, err)
+	assert.Equal(t, ldapserver.LDAPResultSuccess, result.ResultCode)
+	var resp passwordModifyResponse
+	_, err = asn1.Unmarshal(result.ResponseValue, &resp)
+	assert.NoError(t, err)
+	ok, _ = checkPassword("u1", string(resp.GenPasswd), b.changed["u1"])
+	assert.True(t, ok)
+
+	cases := []struct {
+		boundDn  string
+		pm       passwordModifyRequest
+		expected ldapserver.LDAPResultCode
+	}{
+		{u1, passwordModifyRequest{OldPasswd: []byte("wrong"), NewPasswd: []byte("new")}, ldapserver.LDAPResultInvalidCredentials},
+		{u1, passwordModifyRequest{UserIdentity: []byte("cn=u2,ou=people,ou=test,dc=example,dc=com"), NewPasswd: []byte("new")}, ldapserver.LDAPResultInsufficientAccessRights},
+		{u1, passwordModifyRequest{UserIdentity: []byte("u3"), NewPasswd: []byte("new")}, ldapserver.LDAPResultNoSuchObject},
+		{"", passwordModifyRequest{UserIdentity: []byte("u1"), NewPasswd: []byte("new")}, ldapserver.LDAPResultInsufficientAccessRights},
+		{"", passwordModifyRequest{NewPasswd: []byte("new")}, ldapserver.LDAPResultInsufficientAccessRights},
+		{"", passwordModifyRequest{UserIdentity: []byte("u1"), OldPasswd: []byte("wrong"), NewPasswd: []byte("new")}, ldapserver.LDAPResultInvalidCredentials},
+		{"", passwordModifyRequest{UserIdentity: []byte("u3"), OldPasswd: []byte("old"), NewPasswd: []byte("new")}, ldapserver.LDAPResultInvalidCredentials},
+	}
+	for _, tc := range cases {
+		result, err := modify(tc.boundDn, tc.pm)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, result.ResultCode)
+	}
+
+	// anonymous users authenticate with their old password
+	result, err = modify("", passwordModifyRequest{UserIdentity: []byte(u1), OldPasswd: []byte("old"), NewPasswd: []byte("anonymous")})
+	assert.NoError(t, err)
+	assert.Equal(t, ldapserver.LDAPResultSuccess, result.ResultCode)
+	ok, _ = checkPassword("u1", "anonymous", b.changed["u1"])
+	assert.True(t, ok)
+
+	result, _ = s.extended(u1, ldapserver.ExtendedRequest{RequestName: PasswordModifyOID, RequestValue: []byte("invalid")}, nil)
+	assert.Equal(t, ldapserver.LDAPResultProtocolError, result.ResultCode)
+}
+
+func TestServer_passwordModify_service(t *testing.T) {
+	c := newTestConfig()
+	tb := newTestNameBackend()
+	tb.bindFunc = func(username, password string) (bool, error) {
+		return password == "old", nil
+	}
+	b := &testPasswordBackend{TestBackend: tb, changed: make(map[string]string)}
+	c.backend = &testServicePasswordBackend{b}
+	s := NewServer(c)
+	conn, other := net.Pipe()
+	defer other.Close()
+
+	// the service account u1 must not change the password of the user u1
+	service := "cn=u1,ou=services,ou=test,dc=example,dc=com"
+	code, _ := s.bind(service, "service", conn)
+	assert.Equal(t, ldapserver.LDAPResultSuccess, code)
+	for _, pm := range []passwordModifyRequest{{NewPasswd: []byte("new")}, {UserIdentity: []byte("u1"), NewPasswd: []byte("new")}} {
+		value, _ := asn1.Marshal(pm)
+		result, err := s.extended(service, ldapserver.ExtendedRequest{RequestName: PasswordModifyOID, RequestValue: value}, conn)
+		assert.NoError(t, err)
+		assert.Equal(t, ldapserver.LDAPResultInsufficientAccessRights, result.ResultCode)
+	}
+	assert.Empty(t, b.changed)
+}
+
+func TestServer_passwordModify_unsupported(t *testing.T) {
+	c := newTestConfig()
+	c.backend = newTestNameBackend()
+	s := NewServer(c)
+
+	result, err := s.extended("cn=u1,ou=people,ou=test,dc=example,dc=com", ldapserver.ExtendedRequest{RequestName: PasswordModifyOID}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, ldapserver.LDAPResultUnwillingToPerform, result.ResultCode)
+}
+
+func TestServer_whoAmI(t *testing.T) {
+	c := newTestConfig()
+	c.allowAnonBind = true
+	tb := newTestNameBackend()
+	tb.bindFunc = func(username, password string) (bool, error) {
+		return password == "secret", nil
+	}
+	c.backend = tb
+	s := NewServer(c)
+	conn, other := net.Pipe()
+	defer other.Close()
+
+	whoAmI := func() string {
+		result, err := s.extended("", ldapserver.ExtendedRequest{RequestName: WhoAmIOID}, conn)
+		assert.NoError(t, err)
+		assert.Equal(t, ldapserver.LDAPResultSuccess, result.ResultCode)
+		return string(result.ResponseValue)
+	}
+
+	assert.Equal(t, "", whoAmI())
+
+	code, _ := s.bind("CN=u1,ou=people,ou=test,dc=example,dc=com", "secret", conn)
+	assert.Equal(t, ldapserver.LDAPResultSuccess, code)
+	assert.Equal(t, "dn:cn=u1,ou=people,ou=test,dc=example,dc=com", whoAmI())
+
+	code, _ = s.bind("cn=u2,ou=people,ou=test,dc=example,dc=com", "wrong", conn)
+	assert.Equal(t, ldapserver.LDAPResultInvalidCredentials, code)
+	assert.Equal(t, "", whoAmI())
+
+	s.bind("cn=u2,ou=people,ou=test,dc=example,dc=com", "secret", conn)
+	s.unbind("", conn)
+	assert.Equal(t, "", whoAmI())
+
+	s.bind("cn=u2,ou=people,ou=test,dc=example,dc=com", "secret", conn)
+	s.bind("", "", conn)
+	assert.Equal(t, "", whoAmI())
+}
+
+func TestSessionConn_Close(t *testing.T) {
+	sessions := newSessions()
+	conn, other := net.Pipe()
+	defer other.Close()
+	tracked := &sessionConn{Conn: conn, sessions: sessions}
+
+	sessions.bind(tracked, "u1")
+	username, ok := sessions.user(tracked)
+	assert.True(t, ok)
+	assert.Equal(t, "u1", username)
+
+	tracked.Close()
+	_, ok = sessions.user(tracked)
+	assert.False(t, ok)
+}
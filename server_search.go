@@ -4,18 +4,28 @@ import (
 	"fmt"
 	"net"
 	"regexp"
+	"strings"
+	"time"
 
 	"github.com/mark-rushakoff/ldapserver"
 )
 
 func (s *Server) search(boundDn string, req ldapserver.SearchRequest, conn net.Conn) (ldapserver.ServerSearchResult, error) {
 	log.Debugf("search request: bindDn=%s, baseDn=%s, filter=%s", boundDn, req.BaseDN, req.Filter)
+	if s.config.searchLimiter != nil && !s.config.searchLimiter.allow(remoteIP(conn)) {
+		log.Warningf("refusing search from %s, rate limit exceeded", remoteIP(conn))
+		return ldapserver.ServerSearchResult{
+			ResultCode: ldapserver.LDAPResultBusy,
+		}, nil
+	}
 
-	switch req.BaseDN {
-	case s.config.peopleDn:
-		return s.searchUsers(req)
-	case s.config.groupsDn:
-		return s.searchGroups(req)
+	switch {
+	case equalDns(req.BaseDN, s.config.peopleDn):
+		return s.searchUsers(req, s.subject(conn))
+	case equalDns(req.BaseDN, s.config.groupsDn):
+		return s.searchGroups(req, s.subject(conn))
+	case s.config.servicesDn != "" && equalDns(req.BaseDN, s.config.servicesDn):
+		return s.searchServices(req, s.subject(conn))
 	default:
 		return ldapserver.ServerSearchResult{
 			ResultCode: ldapserver.LDAPResultInsufficientAccessRights,
@@ -23,7 +33,7 @@ func (s *Server) search(boundDn string, req ldapserver.SearchRequest, conn net.C
 	}
 }
 
-func (s *Server) searchUsers(req ldapserver.SearchRequest) (ldapserver.ServerSearchResult, error) {
+func (s *Server) searchUsers(req ldapserver.SearchRequest, subject aclSubject) (ldapserver.ServerSearchResult, error) {
 	log.Debug("search people request")
 
 	if filterKey, filterValue, err := parseFilter(req.Filter); err != nil {
@@ -31,20 +41,20 @@ func (s *Server) searchUsers(req ldapserver.SearchRequest) (ldapserver.ServerSea
 		return ldapserver.ServerSearchResult{
 			ResultCode: ldapserver.LDAPResultOperationsError,
 		}, err
-	} else if users, err := s.backend.Users(filterKey, filterValue); err != nil {
+	} else if users, err := s.backend.Users(s.filterKey2backend(filterKey, s.config.userRdnAttr), s.filterValue2name(filterKey, filterValue)); err != nil {
 		log.Errorf("error getting users from backend: %s", err.Error())
 		return ldapserver.ServerSearchResult{
 			ResultCode: ldapserver.LDAPResultOperationsError,
 		}, err
 	} else {
 		return ldapserver.ServerSearchResult{
-			Entries:    users2entries(users, s.config.peopleDn, s.config.groupsDn),
+			Entries:    s.readableEntries(users2entries(s.visibleUsers(users), s.config, req.Attributes), subject, filterAttr(filterKey)),
 			ResultCode: ldapserver.LDAPResultSuccess,
 		}, nil
 	}
 }
 
-func (s *Server) searchGroups(req ldapserver.SearchRequest) (ldapserver.ServerSearchResult, error) {
+func (s *Server) searchGroups(req ldapserver.SearchRequest, subject aclSubject) (ldapserver.ServerSearchResult, error) {
 	log.Debug("search groups request")
 
 	if filterKey, filterValue, err := parseFilter(req.Filter); err != nil {
@@ -52,7 +62,7 @@ func (s *Server) searchGroups(req ldapserver.SearchRequest) (ldapserver.ServerSe
 		return ldapserver.ServerSearchResult{
 			ResultCode: ldapserver.LDAPResultOperationsError,
 		}, err
-	} else if groups, err := s.backend.Groups(filterKey, filterValue); err != nil {
+	} else if groups, err := s.backend.Groups(s.groupFilter2backend(filterKey, filterValue)); err != nil {
 		log.Errorf("error getting groups from backend: %s", err.Error())
 		return ldapserver.ServerSearchResult{
 			ResultCode: ldapserver.LDAPResultOperationsError,
@@ -63,22 +73,139 @@ func (s *Server) searchGroups(req ldapserver.SearchRequest) (ldapserver.ServerSe
 			ResultCode: ldapserver.LDAPResultSuccess,
 		}, nil
 
+	} else if groups, err = s.visibleGroups(groups, filterKey, filterValue); err != nil {
+		log.Errorf("error getting users from backend: %s", err.Error())
+		return ldapserver.ServerSearchResult{
+			ResultCode: ldapserver.LDAPResultOperationsError,
+		}, err
+	} else {
+		return ldapserver.ServerSearchResult{
+			Entries:    s.readableEntries(groups2entries(groups, s.config, req.Attributes), subject, filterAttr(filterKey)),
+			ResultCode: ldapserver.LDAPResultSuccess,
+		}, nil
+	}
+}
+
+// searchServices filters service accounts in the server, backends hold only a few of them.
+func (s *Server) searchServices(req ldapserver.SearchRequest, subject aclSubject) (ldapserver.ServerSearchResult, error) {
+	log.Debug("search services request")
+
+	sb, ok := s.backend.(ServiceBackender)
+	if !ok {
+		return ldapserver.ServerSearchResult{ResultCode: ldapserver.LDAPResultSuccess}, nil
+	}
+	if filterKey, filterValue, err := parseFilter(req.Filter); err != nil {
+		log.Errorf("error parsing search filter: %s", err.Error())
+		return ldapserver.ServerSearchResult{
+			ResultCode: ldapserver.LDAPResultOperationsError,
+		}, err
+	} else if services, err := sb.Services(); err != nil {
+		log.Errorf("error getting services from backend: %s", err.Error())
+		return ldapserver.ServerSearchResult{
+			ResultCode: ldapserver.LDAPResultOperationsError,
+		}, err
 	} else {
+		entries := make([]*ldapserver.Entry, 0, len(services))
+		for _, service := range services {
+			entry := service2entry(&service, s.config)
+			if values, _ := entryAttrValues(entry, filterKey); filterKey == "" || filterValue == "" || filterValue == "*" || attributeType(filterKey).MatchAny(values, filterValue) {
+				entries = append(entries, selectAttributes(entry, req.Attributes))
+			}
+		}
 		return ldapserver.ServerSearchResult{
-			Entries:    groups2entries(groups, s.config.peopleDn, s.config.groupsDn),
+			Entries:    s.readableEntries(entries, subject, filterAttr(filterKey)),
 			ResultCode: ldapserver.LDAPResultSuccess,
 		}, nil
 	}
 }
 
+// visibleUsers drops inactive users if they are configured to be hidden.
+func (s *Server) visibleUsers(users []User) []User {
+	if s.config.inactive != InactiveHide {
+		return users
+	}
+	visible := make([]User, 0, len(users))
+	now := time.Now()
+	for _, user := range users {
+		if user.active(now) {
+			visible = append(visible, user)
+		}
+	}
+	return visible
+}
+
+// visibleGroups drops inactive users from the members of groups if they are configured to be hidden.
+// Groups found by filtering on a hidden member are dropped as well.
+func (s *Server) visibleGroups(groups []Group, filterKey, filterValue string) ([]Group, error) {
+	if s.config.inactive != InactiveHide {
+		return groups, nil
+	}
+	users, err := s.backend.Users("", "")
+	if err != nil {
+		return nil, err
+	}
+	hidden := make(map[string]bool)
+	now := time.Now()
+	for _, user := range users {
+		if !user.active(now) {
+			hidden[strings.ToLower(user.Name)] = true
+		}
+	}
+	if len(hidden) == 0 {
+		return groups, nil
+	}
+	switch filterAttr(filterKey) {
+	case "member", "memberUid":
+		if hidden[strings.ToLower(s.filterValue2name(filterKey, filterValue))] {
+			return []Group{}, nil
+		}
+	}
+
+	visible := make([]Group, len(groups))
+	for i, group := range groups {
+		// copy, groups are shared with the backend
+		group.Members = visibleNames(group.Members, hidden)
+		if memberUids, ok := group.Attr["memberUid"]; ok {
+			attr := make(map[string][]string, len(group.Attr))
+			for k, v := range group.Attr {
+				attr[k] = v
+			}
+			attr["memberUid"] = visibleNames(memberUids, hidden)
+			group.Attr = attr
+		}
+		visible[i] = group
+	}
+	return visible, nil
+}
+
+func visibleNames(names []string, hidden map[string]bool) []string {
+	visible := make([]string, 0, len(names))
+	for _, name := range names {
+		if !hidden[strings.ToLower(name)] {
+			visible = append(visible, name)
+		}
+	}
+	return visible
+}
+
+// parseFilter supports filters in form (key=value) and extensible matches with LDAP_MATCHING_RULE_IN_CHAIN
+// in form (key:1.2.840.113556.1.4.1941:=value), returned as key "key:1.2.840.113556.1.4.1941".
+// Keys of known attributes are returned in their canonical spelling.
 func parseFilter(filter string) (string, string, error) {
-	re := regexp.MustCompile(`^\((\w+)=([^)]+)\)$`)
+	re := regexp.MustCompile(`^\((\w+)(:[\d.]+:)?=([^)]+)\)$`)
 	m := re.FindStringSubmatch(filter)
-	if len(m) < 3 {
+	if len(m) < 4 {
 		return "", "", fmt.Errorf("unsupported search filter '%s', only filter in form '(key=value)' allowed", filter)
 	} else {
-		filterKey := m[1]
-		filterValue := m[2]
+		filterKey := attributeType(m[1]).Name
+		filterValue := m[3]
+		if m[2] != "" {
+			if rule := strings.Trim(m[2], ":"); rule != MatchingRuleInChain {
+				return "", "", fmt.Errorf("unsupported matching rule '%s' in search filter '%s'", rule, filter)
+			} else {
+				filterKey = filterKey + ":" + rule
+			}
+		}
 		if filterKey == "objectClass" && filterValue == "*" {
 			return "", "", nil
 		} else {
@@ -87,6 +214,88 @@ func parseFilter(filter string) (string, string, error) {
 	}
 }
 
+// filterAttr returns the attribute of a filter key, stripping a matching rule.
+func filterAttr(filterKey string) string {
+	return strings.SplitN(filterKey, ":", 2)[0]
+}
+
+// filterKey2backend maps the naming attribute to "cn", backends use it for names.
+func (s *Server) filterKey2backend(filterKey, rdnAttr string) string {
+	if strings.EqualFold(filterKey, rdnAttr) {
+		return "cn"
+	}
+	return filterKey
+}
+
+// groupFilter2backend maps member filters with group DNs to MemberGroup, names of users and groups may collide.
+func (s *Server) groupFilter2backend(filterKey, filterValue string) (string, string) {
+	if filterAttr(filterKey) == "member" {
+		if name, ok := childName(filterValue, s.config.groupRdnAttr, s.config.groupsDn); ok {
+			return MemberGroup + strings.TrimPrefix(filterKey, "member"), name
+		}
+	}
+	return s.filterKey2backend(filterKey, s.config.groupRdnAttr), s.filterValue2name(filterKey, filterValue)
+}
+
+// filterValue2name converts DNs in membership filters to the plain names used by the backends.
+func (s *Server) filterValue2name(filterKey, filterValue string) string {
+	var attr, baseDn string
+	switch filterAttr(filterKey) {
+	case "memberOf":
+		attr, baseDn = s.config.groupRdnAttr, s.config.groupsDn
+	case "member":
+		attr, baseDn = s.config.userRdnAttr, s.config.peopleDn
+	default:
+		return filterValue
+	}
+	if dn, err := ParseDN(filterValue); err == nil && len(dn) > 1 {
+		if t, _, ok := dn[0].Single(); ok && strings.EqualFold(t, attr) {
+			if name, ok := dn2name(attr, baseDn, filterValue); ok {
+				return name
+			}
+		}
+	}
+	return filterValue
+}
+
+// entryByDn looks up the user, group or service account entry with the DN, including operational attributes.
+func (s *Server) entryByDn(dn string) (*ldapserver.Entry, bool, error) {
+	if parsed, err := ParseDN(dn); err != nil || len(parsed) < 2 {
+		return nil, false, nil
+	} else if parent := parsed[1:].String(); equalDns(parent, s.config.peopleDn) {
+		if name, ok := dn2name(s.config.userRdnAttr, s.config.peopleDn, dn); !ok {
+			return nil, false, nil
+		} else if users, err := s.backend.Users("cn", name); err != nil || len(s.visibleUsers(users)) == 0 {
+			return nil, false, err
+		} else {
+			return user2entry(&users[0], s.config), true, nil
+		}
+	} else if equalDns(parent, s.config.groupsDn) {
+		if name, ok := dn2name(s.config.groupRdnAttr, s.config.groupsDn, dn); !ok {
+			return nil, false, nil
+		} else if groups, err := s.backend.Groups("cn", name); err != nil || len(groups) == 0 {
+			return nil, false, err
+		} else if groups, err = s.visibleGroups(groups, "", ""); err != nil {
+			return nil, false, err
+		} else {
+			return group2entry(&groups[0], s.config), true, nil
+		}
+	} else if name, ok := s.bindDn2service(dn); ok {
+		if sb, ok := s.backend.(ServiceBackender); !ok {
+			return nil, false, nil
+		} else if services, err := sb.Services(); err != nil {
+			return nil, false, err
+		} else {
+			for _, service := range services {
+				if strings.EqualFold(service.Name, name) {
+					return service2entry(&service, s.config), true, nil
+				}
+			}
+		}
+	}
+	return nil, false, nil
+}
+
 func appendAttr(attr []*ldapserver.EntryAttribute, name string, values ...string) []*ldapserver.EntryAttribute {
 	if len(values) > 0 {
 		return append(attr, &ldapserver.EntryAttribute{Name: name, Values: values})
@@ -95,49 +304,97 @@ func appendAttr(attr []*ldapserver.EntryAttribute, name string, values ...string
 	}
 }
 
-func user2entry(user *User, peopleDn, groupDn string) *ldapserver.Entry {
+func user2entry(user *User, c *Config) *ldapserver.Entry {
 	attr := make([]*ldapserver.EntryAttribute, 0)
 	classes := make([]string, 0)
 	for k, v := range user.Attr {
 		if k == "objectClass" {
 			classes = append(classes, v...)
-		} else {
+		} else if !isOperationalAttr(k) && !strings.EqualFold(k, "userPassword") {
 			attr = appendAttr(attr, k, v...)
 		}
 	}
 	attr = appendAttr(attr, "cn", user.Name)
+	if _, ok := user.Attr[c.userRdnAttr]; !ok && c.userRdnAttr != "cn" {
+		attr = appendAttr(attr, c.userRdnAttr, user.Name)
+	}
 	attr = appendAttr(attr, "objectClass", appendIfMissing(classes, "inetOrgPerson")...)
-	attr = appendAttr(attr, "memberOf", cns2dns(groupDn, user.Groups)...)
+	attr = appendAttr(attr, "memberOf", c.groupDns(user.Groups)...)
+	dn := c.userDn(user.Name)
+	values := copyAttr(user.Attr)
+	if c.inactive == InactiveMark && !user.active(time.Now()) {
+		lockAttrs(values)
+	}
+	if user.PasswordChangedAt != nil {
+		values["pwdChangedTime"] = []string{generalizedTime(*user.PasswordChangedAt)}
+	}
+	attr = appendOperationalAttrs(attr, dn, values, "inetOrgPerson", user.CreatedAt, user.ModifiedAt)
 
 	return &ldapserver.Entry{
-		DN:         cn2dn(peopleDn, user.Name),
+		DN:         dn,
 		Attributes: attr,
 	}
 }
-func users2entries(users []User, peopleDn, groupDn string) []*ldapserver.Entry {
+func users2entries(users []User, c *Config, attributes []string) []*ldapserver.Entry {
 	entries := make([]*ldapserver.Entry, len(users))
 	for i, user := range users {
-		entries[i] = user2entry(&user, peopleDn, groupDn)
+		entries[i] = selectAttributes(user2entry(&user, c), attributes)
 	}
 	return entries
 }
 
-func group2entry(group *Group, peopleDn, groupDn string) *ldapserver.Entry {
+// service2entry presents service accounts as applicationProcess, they are no members of groups.
+func service2entry(service *User, c *Config) *ldapserver.Entry {
+	attr := make([]*ldapserver.EntryAttribute, 0)
+	classes := make([]string, 0)
+	for k, v := range service.Attr {
+		if k == "objectClass" {
+			classes = append(classes, v...)
+		} else if !isOperationalAttr(k) && !strings.EqualFold(k, "userPassword") {
+			attr = appendAttr(attr, k, v...)
+		}
+	}
+	attr = appendAttr(attr, "cn", service.Name)
+	attr = appendAttr(attr, "objectClass", appendIfMissing(appendIfMissing(classes, "applicationProcess"), "simpleSecurityObject")...)
+	dn := c.serviceDn(service.Name)
+	attr = appendOperationalAttrs(attr, dn, service.Attr, "applicationProcess", service.CreatedAt, service.ModifiedAt)
+
+	return &ldapserver.Entry{
+		DN:         dn,
+		Attributes: attr,
+	}
+}
+
+func group2entry(group *Group, c *Config) *ldapserver.Entry {
 	attr := make([]*ldapserver.EntryAttribute, 0)
+	classes := make([]string, 0)
+	for k, v := range group.Attr {
+		if k == "objectClass" {
+			classes = append(classes, v...)
+		} else if !isOperationalAttr(k) && !strings.EqualFold(k, "userPassword") {
+			attr = appendAttr(attr, k, v...)
+		}
+	}
 	attr = appendAttr(attr, "cn", group.Name)
-	attr = appendAttr(attr, "member", cns2dns(peopleDn, group.Members)...)
-	attr = appendAttr(attr, "objectClass", "groupOfNames")
+	if _, ok := group.Attr[c.groupRdnAttr]; !ok && c.groupRdnAttr != "cn" {
+		attr = appendAttr(attr, c.groupRdnAttr, group.Name)
+	}
+	attr = appendAttr(attr, "member", append(c.userDns(group.Members), c.groupDns(group.MemberGroups)...)...)
+	attr = appendAttr(attr, "memberOf", c.groupDns(group.Groups)...)
+	attr = appendAttr(attr, "objectClass", appendIfMissing(classes, "groupOfNames")...)
+	dn := c.groupDn(group.Name)
+	attr = appendOperationalAttrs(attr, dn, group.Attr, "groupOfNames", group.CreatedAt, group.ModifiedAt)
 
 	return &ldapserver.Entry{
-		DN:         cn2dn(groupDn, group.Name),
+		DN:         dn,
 		Attributes: attr,
 	}
 }
 
-func groups2entries(groups []Group, peopleDn, groupDn string) []*ldapserver.Entry {
+func groups2entries(groups []Group, c *Config, attributes []string) []*ldapserver.Entry {
 	entries := make([]*ldapserver.Entry, len(groups))
 	for i, group := range groups {
-		entries[i] = group2entry(&group, peopleDn, groupDn)
+		entries[i] = selectAttributes(group2entry(&group, c), attributes)
 	}
 	return entries
 }
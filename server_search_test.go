@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
 	"testing"
 
 	"github.com/mark-rushakoff/ldapserver"
@@ -172,3 +174,235 @@ func assertGroup(t *testing.T, name string, entry *ldapserver.Entry) {
 	assert.Contains(t, entry.GetAttributeValues("member"), "cn=u2,ou=people,ou=test,dc=example,dc=com")
 	assert.Contains(t, entry.GetAttributeValues("member"), "cn=u3,ou=people,ou=test,dc=example,dc=com")
 }
+
+func TestParseFilter(t *testing.T) {
+	cases := map[string][]string{
+		"(objectClass=*)":          {"", ""},
+		"(cn=u1)":                  {"cn", "u1"},
+		"(CN=u1)":                  {"cn", "u1"},
+		"(Mail=Kevin@Example.org)": {"mail", "Kevin@Example.org"},
+		"(objectclass=*)":          {"", ""},
+		"(memberOf=cn=g1,ou=groups,ou=test,dc=example,dc=com)":                          {"memberOf", "cn=g1,ou=groups,ou=test,dc=example,dc=com"},
+		"(memberOf:1.2.840.113556.1.4.1941:=cn=g1,ou=groups,ou=test,dc=example,dc=com)": {"memberOf:1.2.840.113556.1.4.1941", "cn=g1,ou=groups,ou=test,dc=example,dc=com"},
+	}
+
+	for filter, expected := range cases {
+		k, v, err := parseFilter(filter)
+		assert.NoError(t, err, "for '%s'", filter)
+		assert.Equal(t, expected[0], k, "for '%s'", filter)
+		assert.Equal(t, expected[1], v, "for '%s'", filter)
+	}
+
+	for _, filter := range []string{"cn=u1", "(&(cn=u1)(mail=foo))", "(memberOf:1.2.3:=g1)"} {
+		_, _, err := parseFilter(filter)
+		assert.Error(t, err, "for '%s'", filter)
+	}
+}
+
+func TestServer_filterValue2name(t *testing.T) {
+	s := &Server{config: newTestConfig()}
+
+	assert.Equal(t, "g1", s.filterValue2name("memberOf", "cn=g1,ou=groups,ou=test,dc=example,dc=com"))
+	assert.Equal(t, "g1", s.filterValue2name("memberOf:"+MatchingRuleInChain, "cn=g1,ou=groups,ou=test,dc=example,dc=com"))
+	assert.Equal(t, "g1", s.filterValue2name("memberOf", "g1"))
+	assert.Equal(t, "u1", s.filterValue2name("member", "cn=u1,ou=people,ou=test,dc=example,dc=com"))
+	assert.Equal(t, "cn=u1,ou=people,ou=test,dc=example,dc=com", s.filterValue2name("cn", "cn=u1,ou=people,ou=test,dc=example,dc=com"))
+
+	s.config.userRdnAttr = "uid"
+	assert.Equal(t, "u1", s.filterValue2name("member", "uid=u1,ou=people,ou=test,dc=example,dc=com"))
+}
+
+func TestServer_search_groups_memberGroup(t *testing.T) {
+	f, _ := ioutil.TempFile(os.TempDir(), "aldapd-config")
+	defer os.Remove(f.Name())
+	f.WriteString(`{
+	"users": [{"name":"team"}],
+	"groups": [
+		{"name":"team", "member": ["team"]},
+		{"name":"dept", "member_group": ["team"]},
+		{"name":"company", "member_group": ["dept"]}
+	]
+}`)
+	f.Close()
+	b, err := NewLocalFileBackend([]string{f.Name()}, nil)
+	assert.NoError(t, err)
+	c := newTestConfig()
+	c.backend = b
+	s := NewServer(c)
+	dns := func(filter string) []string {
+		result, err := s.search("", ldapserver.SearchRequest{BaseDN: c.groupsDn, Filter: filter}, nil)
+		assert.NoError(t, err)
+		dns := make([]string, len(result.Entries))
+		for i, e := range result.Entries {
+			dns[i] = e.DN
+		}
+		return dns
+	}
+
+	// the user and the group named team are told apart by their DNs
+	assert.ElementsMatch(t, []string{c.groupDn("team")}, dns("(member=cn=team,ou=people,ou=test,dc=example,dc=com)"))
+	assert.ElementsMatch(t, []string{c.groupDn("dept")}, dns("(member=cn=team,ou=groups,ou=test,dc=example,dc=com)"))
+	assert.ElementsMatch(t, []string{c.groupDn("dept"), c.groupDn("company")},
+		dns("(member:1.2.840.113556.1.4.1941:=cn=team,ou=groups,ou=test,dc=example,dc=com)"))
+	assert.ElementsMatch(t, []string{c.groupDn("team"), c.groupDn("dept"), c.groupDn("company")},
+		dns("(member:1.2.840.113556.1.4.1941:=cn=team,ou=people,ou=test,dc=example,dc=com)"))
+}
+
+func TestServer_filterKey2backend(t *testing.T) {
+	s := &Server{config: newTestConfig()}
+
+	assert.Equal(t, "cn", s.filterKey2backend("cn", "cn"))
+	assert.Equal(t, "cn", s.filterKey2backend("uid", "uid"))
+	assert.Equal(t, "uid", s.filterKey2backend("uid", "cn"))
+	assert.Equal(t, "cn", s.filterKey2backend("uid", "UID"))
+}
+
+func TestUser2entry_rdnAttr(t *testing.T) {
+	c := newTestConfig()
+	c.userRdnAttr = "uid"
+	c.peopleDn = "ou=users,ou=test,dc=example,dc=com"
+	u := newTestUser("u1")
+
+	entry := user2entry(&u, c)
+	assert.Equal(t, "uid=u1,ou=users,ou=test,dc=example,dc=com", entry.DN)
+	assert.Equal(t, "u1", entry.GetAttributeValue("uid"))
+	assert.Equal(t, "u1", entry.GetAttributeValue("cn"))
+}
+
+func TestGroup2entry_attr(t *testing.T) {
+	g := newTestGroup("g1")
+	g.Attr = map[string][]string{
+		"gidNumber":   {"1001"},
+		"objectClass": {"posixGroup"},
+	}
+
+	entry := group2entry(&g, newTestConfig())
+	assert.Equal(t, "1001", entry.GetAttributeValue("gidNumber"))
+	assert.ElementsMatch(t, []string{"groupOfNames", "posixGroup"}, entry.GetAttributeValues("objectClass"))
+}
+
+func TestGroup2entry_nested(t *testing.T) {
+	g := newTestGroup("g1")
+	g.MemberGroups = []string{"g2"}
+	g.Groups = []string{"g3"}
+
+	entry := group2entry(&g, newTestConfig())
+	assert.Equal(t, 4, len(entry.GetAttributeValues("member")))
+	assert.Contains(t, entry.GetAttributeValues("member"), "cn=g2,ou=groups,ou=test,dc=example,dc=com")
+	assert.Equal(t, []string{"cn=g3,ou=groups,ou=test,dc=example,dc=com"}, entry.GetAttributeValues("memberOf"))
+}
+
+func TestServer_services(t *testing.T) {
+	f, _ := ioutil.TempFile(os.TempDir(), "aldapd-config")
+	defer os.Remove(f.Name())
+	f.WriteString(`{
+	"users": [{"name":"u1"}],
+	"services": [{"name":"nextcloud", "password":"{SSHA}hNsogC9IKy6CFkQzyDSMPmOlAnxcc27o", "attr":{"description":["cloud"]}}]
+}`)
+	f.Close()
+	b, err := NewLocalFileBackend([]string{f.Name()}, nil)
+	assert.NoError(t, err)
+	c := newTestConfig()
+	c.backend = b
+	s := NewServer(c)
+	service := "cn=nextcloud,ou=services,ou=test,dc=example,dc=com"
+
+	code, _ := s.bind(service, "bar", nil)
+	assert.Equal(t, ldapserver.LDAPResultInvalidCredentials, code)
+	code, _ = s.bind("cn=nextcloud,ou=people,ou=test,dc=example,dc=com", "foo", nil)
+	assert.Equal(t, ldapserver.LDAPResultInvalidCredentials, code)
+	code, _ = s.bind(service, "foo", nil)
+	assert.Equal(t, ldapserver.LDAPResultSuccess, code)
+	assert.Equal(t, aclSubject{name: "nextcloud", dn: service, service: true}, s.subject(nil))
+	result, _ := s.whoAmI(nil)
+	assert.Equal(t, "dn:"+service, string(result.ResponseValue))
+
+	people, _ := s.search(service, ldapserver.SearchRequest{BaseDN: c.peopleDn, Filter: "(objectClass=*)"}, nil)
+	assert.Len(t, people.Entries, 1)
+	assert.Equal(t, "cn=u1,ou=people,ou=test,dc=example,dc=com", people.Entries[0].DN)
+
+	services, _ := s.search(service, ldapserver.SearchRequest{BaseDN: c.servicesDn, Filter: "(description=Cloud)"}, nil)
+	assert.Equal(t, ldapserver.LDAPResultSuccess, services.ResultCode)
+	assert.Len(t, services.Entries, 1)
+	assert.Equal(t, service, services.Entries[0].DN)
+	assert.Equal(t, []string{"applicationProcess", "simpleSecurityObject"}, services.Entries[0].GetAttributeValues("objectClass"))
+	assert.Empty(t, services.Entries[0].GetAttributeValues("userPassword"))
+
+	services, _ = s.search(service, ldapserver.SearchRequest{BaseDN: c.servicesDn, Filter: "(cn=other)"}, nil)
+	assert.Empty(t, services.Entries)
+
+	for _, filter := range []string{"(cn=*)", "(objectClass=*)"} {
+		services, _ = s.search(service, ldapserver.SearchRequest{BaseDN: c.servicesDn, Filter: filter}, nil)
+		assert.Len(t, services.Entries, 1, "for %s", filter)
+	}
+}
+
+func TestServer_search_inactive(t *testing.T) {
+	disabled := newTestUser("u2")
+	disabled.Disabled = true
+	c := newTestConfig()
+	c.backend = &TestBackend{usersFunc: func(filterKey, filterValue string) ([]User, error) {
+		return []User{newTestUser("u1"), disabled}, nil
+	}}
+	s := NewServer(c)
+	req := ldapserver.SearchRequest{BaseDN: c.peopleDn, Filter: "(objectClass=*)", Attributes: []string{"cn", "nsAccountLock", "pwdAccountLockedTime"}}
+
+	c.inactive = InactiveShow
+	result, _ := s.search("", req, nil)
+	assert.Len(t, result.Entries, 2)
+	assert.Empty(t, result.Entries[1].GetAttributeValues("nsAccountLock"))
+
+	c.inactive = InactiveMark
+	result, _ = s.search("", req, nil)
+	assert.Len(t, result.Entries, 2)
+	assert.Empty(t, result.Entries[0].GetAttributeValues("nsAccountLock"))
+	assert.Equal(t, []string{"TRUE"}, result.Entries[1].GetAttributeValues("nsAccountLock"))
+	assert.Equal(t, []string{"000001010000Z"}, result.Entries[1].GetAttributeValues("pwdAccountLockedTime"))
+
+	c.inactive = InactiveHide
+	result, _ = s.search("", req, nil)
+	assert.Len(t, result.Entries, 1)
+	assert.Equal(t, "u1", result.Entries[0].GetAttributeValue("cn"))
+}
+
+func TestServer_search_inactiveMembers(t *testing.T) {
+	disabled := newTestUser("u2")
+	disabled.Disabled = true
+	g1 := newTestGroup("g1")
+	g1.Attr = map[string][]string{"memberUid": {"u1", "u2", "u3"}}
+	c := newTestConfig()
+	c.backend = &TestBackend{
+		usersFunc: func(filterKey, filterValue string) ([]User, error) {
+			return []User{newTestUser("u1"), disabled, newTestUser("u3")}, nil
+		},
+		groupsFunc: func(filterKey, filterValue string) ([]Group, error) {
+			return []Group{g1}, nil
+		},
+	}
+	s := NewServer(c)
+	req := ldapserver.SearchRequest{BaseDN: c.groupsDn, Filter: "(objectClass=*)"}
+
+	c.inactive = InactiveMark
+	result, _ := s.search("", req, nil)
+	assert.Len(t, result.Entries, 1)
+	assert.Len(t, result.Entries[0].GetAttributeValues("member"), 3)
+
+	c.inactive = InactiveHide
+	result, _ = s.search("", req, nil)
+	assert.Len(t, result.Entries, 1)
+	assert.Equal(t, []string{"cn=u1,ou=people,ou=test,dc=example,dc=com", "cn=u3,ou=people,ou=test,dc=example,dc=com"}, result.Entries[0].GetAttributeValues("member"))
+	assert.Equal(t, []string{"u1", "u3"}, result.Entries[0].GetAttributeValues("memberUid"))
+	assert.Equal(t, []string{"u1", "u2", "u3"}, g1.Attr["memberUid"])
+
+	for _, filter := range []string{"(member=cn=u2,ou=people,ou=test,dc=example,dc=com)", "(memberUid=U2)"} {
+		result, _ = s.search("", ldapserver.SearchRequest{BaseDN: c.groupsDn, Filter: filter}, nil)
+		assert.Empty(t, result.Entries, "for %s", filter)
+	}
+	result, _ = s.search("", ldapserver.SearchRequest{BaseDN: c.groupsDn, Filter: "(member=cn=u1,ou=people,ou=test,dc=example,dc=com)"}, nil)
+	assert.Len(t, result.Entries, 1)
+
+	entry, ok, err := s.entryByDn("cn=g1,ou=groups,ou=test,dc=example,dc=com")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Len(t, entry.GetAttributeValues("member"), 2)
+}
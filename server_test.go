@@ -26,6 +26,9 @@ func (tb *TestBackend) Check(username, password string) (bool, error) {
 }
 
 func (tb *TestBackend) Users(filterKey, filterValue string) ([]User, error) {
+	if tb.usersFunc == nil {
+		return nil, nil
+	}
 	return tb.usersFunc(filterKey, filterValue)
 }
 
@@ -37,6 +40,18 @@ func (tb *TestBackend) Reload() error {
 	return nil
 }
 
+func newTestConfig() *Config {
+	return &Config{
+		allowAnonBind: false,
+		baseDn:        "ou=test,dc=example,dc=com",
+		peopleDn:      "ou=people,ou=test,dc=example,dc=com",
+		groupsDn:      "ou=groups,ou=test,dc=example,dc=com",
+		servicesDn:    "ou=services,ou=test,dc=example,dc=com",
+		userRdnAttr:   "cn",
+		groupRdnAttr:  "cn",
+	}
+}
+
 func startTestServer(t *testing.T) (*Server, *TestBackend, string) {
 	logging.SetLevel(logging.DEBUG, "")
 
@@ -44,14 +59,10 @@ func startTestServer(t *testing.T) (*Server, *TestBackend, string) {
 	listen := fmt.Sprintf("%s:%d", listenAddr, listenPort)
 
 	tb := &TestBackend{}
-	config := &Config{
-		listenAddr:    listenAddr,
-		listenPort:    listenPort,
-		allowAnonBind: false,
-		baseDn:        "ou=test,dc=example,dc=com",
-		peopleDn:      "ou=people,ou=test,dc=example,dc=com",
-		groupsDn:      "ou=groups,ou=test,dc=example,dc=com",
-		backend:       tb}
+	config := newTestConfig()
+	config.listenAddr = listenAddr
+	config.listenPort = listenPort
+	config.backend = tb
 
 	s := StartServer(config)
 	assert.NotNil(t, s)
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/mark-rushakoff/ldapserver"
+)
+
+const (
+	entryUser  = "user"
+	entryGroup = "group"
+)
+
+// writeError carries the LDAP result code of a rejected write.
+type writeError struct {
+	code ldapserver.LDAPResultCode
+	msg  string
+}
+
+func (e *writeError) Error() string {
+	return e.msg
+}
+
+func newWriteError(code ldapserver.LDAPResultCode, format string, args ...interface{}) error {
+	return &writeError{code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+func (s *Server) add(boundDn string, req ldapserver.AddRequest, conn net.Conn) (ldapserver.LDAPResultCode, error) {
+	log.Debugf("add request: bindDn=%s, dn=%s", boundDn, req.DN)
+
+	attrs := make(map[string][]string)
+	for _, a := range req.Attributes {
+		name := attributeType(a.AttrType).Name
+		attrs[name] = append(attrs[name], a.AttrVals...)
+	}
+	return s.write(conn, req.DN, nil, func(data *BackendData, kind, name string) error {
+		switch kind {
+		case entryUser:
+			if findUser(data, name) >= 0 {
+				return newWriteError(ldapserver.LDAPResultEntryAlreadyExists, "user %s already exists", name)
+			}
+			user := &User{Name: name}
+			if err := s.setUserAttrs(user, attrs); err != nil {
+				return err
+			}
+			data.Users = append(data.Users, user)
+		case entryGroup:
+			if findGroup(data, name) >= 0 {
+				return newWriteError(ldapserver.LDAPResultEntryAlreadyExists, "group %s already exists", name)
+			}
+			group := &Group{Name: name}
+			if err := s.setGroupAttrs(data, group, attrs); err != nil {
+				return err
+			}
+			data.Groups = append(data.Groups, group)
+		}
+		return nil
+	})
+}
+
+func (s *Server) modify(boundDn string, req ldapserver.ModifyRequest, conn net.Conn) (ldapserver.LDAPResultCode, error) {
+	log.Debugf("modify request: bindDn=%s, dn=%s", boundDn, req.DN)
+
+	attrs := make([]string, len(req.Changes))
+	for i, c := range req.Changes {
+		attrs[i] = attributeType(c.Modification.AttrType).Name
+	}
+	return s.write(conn, req.DN, attrs, func(data *BackendData, kind, name string) error {
+		switch kind {
+		case entryUser:
+			if i := findUser(data, name); i < 0 {
+				return newWriteError(ldapserver.LDAPResultNoSuchObject, "no user %s", name)
+			} else if attrs, err := applyChanges(s.userAttrs(data.Users[i]), req.Changes); err != nil {
+				return err
+			} else {
+				return s.setUserAttrs(data.Users[i], attrs)
+			}
+		default:
+			if i := findGroup(data, name); i < 0 {
+				return newWriteError(ldapserver.LDAPResultNoSuchObject, "no group %s", name)
+			} else if attrs, err := applyChanges(s.groupAttrs(data.Groups[i]), req.Changes); err != nil {
+				return err
+			} else {
+				return s.setGroupAttrs(data, data.Groups[i], attrs)
+			}
+		}
+	})
+}
+
+// delete removes the entry and its group memberships.
+func (s *Server) delete(boundDn, deleteDn string, conn net.Conn) (ldapserver.LDAPResultCode, error) {
+	log.Debugf("delete request: bindDn=%s, dn=%s", boundDn, deleteDn)
+
+	return s.write(conn, deleteDn, nil, func(data *BackendData, kind, name string) error {
+		switch kind {
+		case entryUser:
+			i := findUser(data, name)
+			if i < 0 {
+				return newWriteError(ldapserver.LDAPResultNoSuchObject, "no user %s", name)
+			}
+			name = data.Users[i].Name
+			data.Users = append(data.Users[:i], data.Users[i+1:]...)
+			for _, g := range data.Groups {
+				g.Members = remove(g.Members, name)
+			}
+		default:
+			i := findGroup(data, name)
+			if i < 0 {
+				return newWriteError(ldapserver.LDAPResultNoSuchObject, "no group %s", name)
+			}
+			name = data.Groups[i].Name
+			data.Groups = append(data.Groups[:i], data.Groups[i+1:]...)
+			for _, g := range data.Groups {
+				g.MemberGroups = remove(g.MemberGroups, name)
+			}
+		}
+		return nil
+	})
+}
+
+// modifyDn renames the entry and its group memberships, entries can't be moved.
+func (s *Server) modifyDn(boundDn string, req ldapserver.ModifyDNRequest, conn net.Conn) (ldapserver.LDAPResultCode, error) {
+	log.Debugf("modify dn request: bindDn=%s, dn=%s, newRdn=%s", boundDn, req.DN, req.NewRDN)
+
+	return s.write(conn, req.DN, nil, func(data *BackendData, kind, name string) error {
+		rdnAttr, parentDn := s.config.userRdnAttr, s.config.peopleDn
+		if kind == entryGroup {
+			rdnAttr, parentDn = s.config.groupRdnAttr, s.config.groupsDn
+		}
+		if req.NewSuperior != "" && !equalDns(req.NewSuperior, parentDn) {
+			return newWriteError(ldapserver.LDAPResultUnwillingToPerform, "moving entries is not supported")
+		}
+		rdn, err := ParseDN(req.NewRDN)
+		if err != nil || len(rdn) != 1 {
+			return newWriteError(ldapserver.LDAPResultInvalidDNSyntax, "invalid new rdn %s", req.NewRDN)
+		}
+		attr, newName, ok := rdn[0].Single()
+		if !ok || !strings.EqualFold(attr, rdnAttr) || newName == "" {
+			return newWriteError(ldapserver.LDAPResultNamingViolation, "new rdn must be a single %s", rdnAttr)
+		}
+
+		switch kind {
+		case entryUser:
+			i := findUser(data, name)
+			if i < 0 {
+				return newWriteError(ldapserver.LDAPResultNoSuchObject, "no user %s", name)
+			} else if j := findUser(data, newName); j >= 0 && j != i {
+				return newWriteError(ldapserver.LDAPResultEntryAlreadyExists, "user %s already exists", newName)
+			}
+			for _, g := range data.Groups {
+				g.Members = rename(g.Members, data.Users[i].Name, newName)
+			}
+			data.Users[i].Name = newName
+		default:
+			i := findGroup(data, name)
+			if i < 0 {
+				return newWriteError(ldapserver.LDAPResultNoSuchObject, "no group %s", name)
+			} else if j := findGroup(data, newName); j >= 0 && j != i {
+				return newWriteError(ldapserver.LDAPResultEntryAlreadyExists, "group %s already exists", newName)
+			}
+			for _, g := range data.Groups {
+				g.MemberGroups = rename(g.MemberGroups, data.Groups[i].Name, newName)
+			}
+			data.Groups[i].Name = newName
+		}
+		return nil
+	})
+}
+
+// write checks the permissions of the connection and the target DN and applies the change to the writable backend.
+// Changes of attrs need write access to these attributes, others to the whole entry.
+func (s *Server) write(conn net.Conn, dn string, attrs []string, change func(data *BackendData, kind, name string) error) (ldapserver.LDAPResultCode, error) {
+	writer, ok := s.backend.(SnapshotWriter)
+	if !ok || !writer.Writable() {
+		return ldapserver.LDAPResultUnwillingToPerform, nil
+	} else if !s.canWrite(s.subject(conn), dn, attrs) {
+		return ldapserver.LDAPResultInsufficientAccessRights, nil
+	}
+	kind, name, err := s.writeTarget(dn)
+	if err == nil {
+		err = writer.Write(func(data *BackendData) error {
+			return change(data, kind, name)
+		})
+	}
+
+	var we *writeError
+	if err == nil {
+		return ldapserver.LDAPResultSuccess, nil
+	} else if errors.As(err, &we) {
+		log.Warningf("rejecting write of %s: %s", dn, we.msg)
+		return we.code, nil
+	} else {
+		log.Errorf("error writing %s: %s", dn, err.Error())
+		return ldapserver.LDAPResultOperationsError, err
+	}
+}
+
+func (s *Server) canWrite(subject aclSubject, dn string, attrs []string) bool {
+	if len(attrs) == 0 {
+		return s.allowed(subject, dn, "", AccessWrite)
+	}
+	for _, attr := range attrs {
+		if !s.allowed(subject, dn, attr, AccessWrite) {
+			return false
+		}
+	}
+	return true
+}
+
+// writeTarget returns whether the DN names a user or a group and its name.
+func (s *Server) writeTarget(dn string) (string, string, error) {
+	parsed, err := ParseDN(dn)
+	if err != nil || len(parsed) < 2 {
+		return "", "", newWriteError(ldapserver.LDAPResultInvalidDNSyntax, "invalid dn %s", dn)
+	}
+	kind, rdnAttr := entryUser, s.config.userRdnAttr
+	if parent := parsed[1:].String(); equalDns(parent, s.config.groupsDn) {
+		kind, rdnAttr = entryGroup, s.config.groupRdnAttr
+	} else if !equalDns(parent, s.config.peopleDn) {
+		return "", "", newWriteError(ldapserver.LDAPResultNoSuchObject, "dn %s is neither below %s nor %s", dn, s.config.peopleDn, s.config.groupsDn)
+	}
+	if attr, name, ok := parsed[0].Single(); !ok || !strings.EqualFold(attr, rdnAttr) || name == "" {
+		return "", "", newWriteError(ldapserver.LDAPResultNamingViolation, "rdn of %s must be a single %s", dn, rdnAttr)
+	} else {
+		return kind, name, nil
+	}
+}
+
+// userAttrs returns the attributes of the stored user as seen by LDAP clients, except derived ones.
+func (s *Server) userAttrs(user *User) map[string][]string {
+	attrs := copyAttr(user.Attr)
+	if user.Password != "" {
+		attrs["userPassword"] = []string{user.Password}
+	}
+	return attrs
+}
+
+// groupAttrs returns the attributes of the stored group as seen by LDAP clients, except derived ones.
+func (s *Server) groupAttrs(group *Group) map[string][]string {
+	attrs := copyAttr(group.Attr)
+	if members := append(s.config.userDns(group.Members), s.config.groupDns(group.MemberGroups)...); len(members) > 0 {
+		attrs["member"] = members
+	}
+	return attrs
+}
+
+// setUserAttrs checks the attributes against the schema and stores them, new passwords are hashed.
+func (s *Server) setUserAttrs(user *User, attrs map[string][]string) error {
+	password := attrs["userPassword"]
+	delete(attrs, "userPassword")
+	if err := checkAttrs(attrs, user.Name, s.config.userRdnAttr, []string{"groupOfNames", "posixGroup"}); err != nil {
+		return err
+	}
+
+	previous := user.Password
+	switch {
+	case len(password) > 1:
+		return newWriteError(ldapserver.LDAPResultConstraintViolation, "userPassword must have a single value")
+	case len(password) == 0:
+		user.Password = ""
+	case isPasswordHash(password[0]):
+		user.Password = password[0]
+	default:
+		if hash, err := hashPassword(password[0]); err != nil {
+			return err
+		} else {
+			user.Password = hash
+		}
+	}
+	if user.Password != previous && user.Password != "" {
+		now := time.Now().UTC().Truncate(time.Second)
+		user.PasswordChangedAt = &now
+	}
+	user.Attr = attrs
+	return nil
+}
+
+// setGroupAttrs checks the attributes against the schema and stores them.
+// Members must be existing users or groups.
+func (s *Server) setGroupAttrs(data *BackendData, group *Group, attrs map[string][]string) error {
+	member := attrs["member"]
+	delete(attrs, "member")
+	if err := checkAttrs(attrs, group.Name, s.config.groupRdnAttr, []string{"inetOrgPerson", "person", "posixAccount"}); err != nil {
+		return err
+	}
+
+	members, memberGroups := make([]string, 0), make([]string, 0)
+	for _, dn := range member {
+		if i := s.findMember(data.Users, dn); i >= 0 {
+			members = appendIfMissing(members, data.Users[i].Name)
+		} else if i := s.findMemberGroup(data.Groups, dn); i >= 0 {
+			if data.Groups[i].Name == group.Name {
+				return newWriteError(ldapserver.LDAPResultConstraintViolation, "group %s can't be a member of itself", group.Name)
+			}
+			memberGroups = appendIfMissing(memberGroups, data.Groups[i].Name)
+		} else {
+			return newWriteError(ldapserver.LDAPResultConstraintViolation, "member %s is no known user or group", dn)
+		}
+	}
+	group.Members = members
+	group.MemberGroups = memberGroups
+	group.Attr = attrs
+	return nil
+}
+
+// checkAttrs validates attributes of an entry and drops the naming attributes derived from its name.
+func checkAttrs(attrs map[string][]string, name, rdnAttr string, forbiddenClasses []string) error {
+	for k, values := range attrs {
+		t := attributeType(k)
+		switch {
+		case len(values) == 0:
+			delete(attrs, k)
+		case strings.EqualFold(k, "cn") || strings.EqualFold(k, rdnAttr):
+			if len(values) != 1 || !t.Match(values[0], name) {
+				return newWriteError(ldapserver.LDAPResultNotAllowedOnRDN, "%s must equal the entry's name %s", k, name)
+			}
+			delete(attrs, k)
+		case strings.EqualFold(k, "memberOf") || (isOperationalAttr(k) && !strings.EqualFold(k, "entryUUID")):
+			return newWriteError(ldapserver.LDAPResultConstraintViolation, "%s can't be modified", k)
+		case t.SingleValue && len(values) > 1:
+			return newWriteError(ldapserver.LDAPResultConstraintViolation, "%s must have a single value", k)
+		case t.Equality == IntegerMatch:
+			for _, v := range values {
+				if !t.Match(v, v) {
+					return newWriteError(ldapserver.LDAPResultInvalidAttributeSyntax, "%s must be an integer, got %q", k, v)
+				}
+			}
+		case k == "objectClass":
+			for _, class := range forbiddenClasses {
+				if containsFold(values, class) {
+					return newWriteError(ldapserver.LDAPResultObjectClassViolation, "objectClass %s is not allowed", class)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// applyChanges applies the modifications of a modify request to the attributes.
+func applyChanges(attrs map[string][]string, changes []ldapserver.Change) (map[string][]string, error) {
+	for _, c := range changes {
+		t := attributeType(c.Modification.AttrType)
+		name := t.Name
+		for k := range attrs {
+			if strings.EqualFold(k, name) && k != name {
+				attrs[name] = attrs[k]
+				delete(attrs, k)
+			}
+		}
+		switch c.Operation {
+		case ldapserver.AddAttribute:
+			for _, v := range c.Modification.AttrVals {
+				if t.MatchAny(attrs[name], v) {
+					return nil, newWriteError(ldapserver.LDAPResultAttributeOrValueExists, "%s already has value %s", name, v)
+				}
+				attrs[name] = append(attrs[name], v)
+			}
+		case ldapserver.DeleteAttribute:
+			if _, ok := attrs[name]; !ok {
+				return nil, newWriteError(ldapserver.LDAPResultNoSuchAttribute, "no attribute %s", name)
+			} else if len(c.Modification.AttrVals) == 0 || name == "userPassword" {
+				delete(attrs, name)
+				continue
+			}
+			for _, v := range c.Modification.AttrVals {
+				if !t.MatchAny(attrs[name], v) {
+					return nil, newWriteError(ldapserver.LDAPResultNoSuchAttribute, "%s has no value %s", name, v)
+				}
+				values := make([]string, 0)
+				for _, existing := range attrs[name] {
+					if !t.Match(existing, v) {
+						values = append(values, existing)
+					}
+				}
+				attrs[name] = values
+			}
+		case ldapserver.ReplaceAttribute:
+			attrs[name] = c.Modification.AttrVals
+		default:
+			return nil, newWriteError(ldapserver.LDAPResultProtocolError, "unknown modify operation %d", c.Operation)
+		}
+	}
+	return attrs, nil
+}
+
+// findUser returns the index of the user, names are matched exactly before case-insensitively.
+func findUser(data *BackendData, name string) int {
+	names := make([]string, len(data.Users))
+	for i, u := range data.Users {
+		names[i] = u.Name
+	}
+	return findName(names, name)
+}
+
+// findGroup returns the index of the group, names are matched exactly before case-insensitively.
+func findGroup(data *BackendData, name string) int {
+	names := make([]string, len(data.Groups))
+	for i, g := range data.Groups {
+		names[i] = g.Name
+	}
+	return findName(names, name)
+}
+
+func (s *Server) findMember(users []*User, dn string) int {
+	if name, ok := dn2name(s.config.userRdnAttr, s.config.peopleDn, dn); ok {
+		return findUser(&BackendData{Users: users}, name)
+	}
+	return -1
+}
+
+func (s *Server) findMemberGroup(groups []*Group, dn string) int {
+	if name, ok := dn2name(s.config.groupRdnAttr, s.config.groupsDn, dn); ok {
+		return findGroup(&BackendData{Groups: groups}, name)
+	}
+	return -1
+}
+
+func findName(names []string, name string) int {
+	for i, n := range names {
+		if n == name {
+			return i
+		}
+	}
+	for i, n := range names {
+		if strings.EqualFold(n, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+func remove(arr []string, s string) []string {
+	r := make([]string, 0, len(arr))
+	for _, e := range arr {
+		if e != s {
+			r = append(r, e)
+		}
+	}
+	return r
+}
+
+func rename(arr []string, from, to string) []string {
+	r := make([]string, len(arr))
+	for i, e := range arr {
+		if e == from {
+			e = to
+		}
+		r[i] = e
+	}
+	return r
+}
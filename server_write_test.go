@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/mark-rushakoff/ldapserver"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testAdminDn = "cn=u1,ou=people,ou=test,dc=example,dc=com"
+)
+
+func newTestWriteServer(t *testing.T) (*Server, *localFileBackend, string) {
+	f, _ := ioutil.TempFile(os.TempDir(), "aldapd-config")
+	f.WriteString(validTestConfig)
+	f.Close()
+
+	b, err := NewLocalFileBackend([]string{f.Name()}, &SnapshotConfig{writable: true})
+	assert.NoError(t, err)
+	c := newTestConfig()
+	c.backend = b
+	c.writers = []string{testAdminDn}
+	s := NewServer(c)
+	s.sessions.bind(nil, "u1")
+	return s, b, f.Name()
+}
+
+func TestNewLocalFileBackend_writable(t *testing.T) {
+	_, err := NewLocalFileBackend([]string{"a", "b"}, &SnapshotConfig{writable: true})
+	assert.Error(t, err)
+	_, err = NewLocalFileBackend([]string{"a"}, &SnapshotConfig{writable: true, key: make([]byte, 32)})
+	assert.Error(t, err)
+}
+
+func TestServer_add(t *testing.T) {
+	s, b, file := newTestWriteServer(t)
+	defer os.Remove(file)
+
+	code, err := s.add("", ldapserver.AddRequest{
+		DN: "cn=u3,ou=people,ou=test,dc=example,dc=com",
+		Attributes: []ldapserver.Attribute{
+			{AttrType: "objectClass", AttrVals: []string{"inetOrgPerson"}},
+			{AttrType: "CN", AttrVals: []string{"u3"}},
+			{AttrType: "Mail", AttrVals: []string{"u3@example.org"}},
+			{AttrType: "uidNumber", AttrVals: []string{"1003"}},
+			{AttrType: "userPassword", AttrVals: []string{"secret"}},
+		},
+	}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, ldapserver.LDAPResultSuccess, code)
+
+	users, _ := b.Users("cn", "u3")
+	assert.Equal(t, 1, len(users))
+	assert.Equal(t, []string{"u3@example.org"}, users[0].Attr["mail"])
+	assert.Nil(t, users[0].Attr["cn"])
+	ok, _ := b.Check("u3", "secret")
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), b.version)
+
+	var data BackendData
+	content, _ := ioutil.ReadFile(file)
+	assert.NoError(t, json.Unmarshal(content, &data))
+	assert.Equal(t, 3, len(data.Users))
+	assert.NotContains(t, string(content), "secret")
+
+	code, _ = s.add("", ldapserver.AddRequest{
+		DN:         "cn=g3,ou=groups,ou=test,dc=example,dc=com",
+		Attributes: []ldapserver.Attribute{{AttrType: "member", AttrVals: []string{"cn=u3,ou=people,ou=test,dc=example,dc=com", "CN=g1,ou=groups,ou=test,dc=example,dc=com"}}},
+	}, nil)
+	assert.Equal(t, ldapserver.LDAPResultSuccess, code)
+	groups, _ := b.Groups("cn", "g3")
+	assert.Equal(t, []string{"u3"}, groups[0].Members)
+	assert.Equal(t, []string{"g1"}, groups[0].MemberGroups)
+
+	cases := []struct {
+		dn       string
+		attrs    []ldapserver.Attribute
+		expected ldapserver.LDAPResultCode
+	}{
+		{"cn=u3,ou=people,ou=test,dc=example,dc=com", nil, ldapserver.LDAPResultEntryAlreadyExists},
+		{"cn=u4,ou=people,ou=test,dc=example,dc=com", []ldapserver.Attribute{{AttrType: "cn", AttrVals: []string{"other"}}}, ldapserver.LDAPResultNotAllowedOnRDN},
+		{"cn=u5,ou=people,ou=test,dc=example,dc=com", []ldapserver.Attribute{{AttrType: "uidNumber", AttrVals: []string{"abc"}}}, ldapserver.LDAPResultInvalidAttributeSyntax},
+		{"cn=u6,ou=people,ou=test,dc=example,dc=com", []ldapserver.Attribute{{AttrType: "uidNumber", AttrVals: []string{"1", "2"}}}, ldapserver.LDAPResultConstraintViolation},
+		{"cn=u7,ou=people,ou=test,dc=example,dc=com", []ldapserver.Attribute{{AttrType: "memberOf", AttrVals: []string{"cn=g1,ou=groups,ou=test,dc=example,dc=com"}}}, ldapserver.LDAPResultConstraintViolation},
+		{"cn=u8,ou=people,ou=test,dc=example,dc=com", []ldapserver.Attribute{{AttrType: "objectClass", AttrVals: []string{"groupOfNames"}}}, ldapserver.LDAPResultObjectClassViolation},
+		{"cn=g4,ou=groups,ou=test,dc=example,dc=com", []ldapserver.Attribute{{AttrType: "member", AttrVals: []string{"cn=unknown,ou=people,ou=test,dc=example,dc=com"}}}, ldapserver.LDAPResultConstraintViolation},
+		{"uid=u9,ou=people,ou=test,dc=example,dc=com", nil, ldapserver.LDAPResultNamingViolation},
+		{"cn=u9,ou=other,ou=test,dc=example,dc=com", nil, ldapserver.LDAPResultNoSuchObject},
+		{"cn=u9,ou=people,ou=test,dc=example,dc=com,,", nil, ldapserver.LDAPResultInvalidDNSyntax},
+	}
+	for _, tc := range cases {
+		code, err := s.add("", ldapserver.AddRequest{DN: tc.dn, Attributes: tc.attrs}, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, code, "for %s", tc.dn)
+	}
+	assert.Equal(t, int64(2), b.version)
+}
+
+func TestServer_add_permissions(t *testing.T) {
+	s, b, file := newTestWriteServer(t)
+	defer os.Remove(file)
+	req := ldapserver.AddRequest{DN: "cn=u3,ou=people,ou=test,dc=example,dc=com"}
+
+	s.sessions.bind(nil, "u2")
+	code, _ := s.add("", req, nil)
+	assert.Equal(t, ldapserver.LDAPResultInsufficientAccessRights, code)
+
+	s.sessions.forget(nil)
+	code, _ = s.add("", req, nil)
+	assert.Equal(t, ldapserver.LDAPResultInsufficientAccessRights, code)
+
+	s.sessions.bind(nil, "u1")
+	b.snapshot.writable = false
+	code, _ = s.add("", req, nil)
+	assert.Equal(t, ldapserver.LDAPResultUnwillingToPerform, code)
+}
+
+func TestServer_modify(t *testing.T) {
+	s, b, file := newTestWriteServer(t)
+	defer os.Remove(file)
+	u1 := "cn=u1,ou=people,ou=test,dc=example,dc=com"
+
+	code, err := s.modify("", ldapserver.ModifyRequest{DN: u1, Changes: []ldapserver.Change{
+		{Operation: ldapserver.AddAttribute, Modification: ldapserver.PartialAttribute{AttrType: "mail", AttrVals: []string{"u1@example.org"}}},
+		{Operation: ldapserver.ReplaceAttribute, Modification: ldapserver.PartialAttribute{AttrType: "A1", AttrVals: []string{"v2", "v3"}}},
+		{Operation: ldapserver.DeleteAttribute, Modification: ldapserver.PartialAttribute{AttrType: "a1", AttrVals: []string{"V2"}}},
+		{Operation: ldapserver.ReplaceAttribute, Modification: ldapserver.PartialAttribute{AttrType: "userPassword", AttrVals: []string{"changed"}}},
+	}}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, ldapserver.LDAPResultSuccess, code)
+	users, _ := b.Users("cn", "u1")
+	assert.Equal(t, []string{"u1@example.org"}, users[0].Attr["mail"])
+	assert.Equal(t, []string{"v3"}, users[0].Attr["a1"])
+	ok, _ := b.Check("u1", "changed")
+	assert.True(t, ok)
+
+	code, _ = s.modify("", ldapserver.ModifyRequest{DN: "cn=g2,ou=groups,ou=test,dc=example,dc=com", Changes: []ldapserver.Change{
+		{Operation: ldapserver.AddAttribute, Modification: ldapserver.PartialAttribute{AttrType: "member", AttrVals: []string{"cn=u2,ou=people,ou=test,dc=example,dc=com"}}},
+	}}, nil)
+	assert.Equal(t, ldapserver.LDAPResultSuccess, code)
+	groups, _ := b.Groups("cn", "g2")
+	assert.Equal(t, []string{"u1", "u2"}, groups[0].Members)
+
+	for expected, change := range map[ldapserver.LDAPResultCode]ldapserver.Change{
+		ldapserver.LDAPResultAttributeOrValueExists: {Operation: ldapserver.AddAttribute, Modification: ldapserver.PartialAttribute{AttrType: "mail", AttrVals: []string{"U1@example.org"}}},
+		ldapserver.LDAPResultNoSuchAttribute:        {Operation: ldapserver.DeleteAttribute, Modification: ldapserver.PartialAttribute{AttrType: "title"}},
+		ldapserver.LDAPResultNotAllowedOnRDN:        {Operation: ldapserver.ReplaceAttribute, Modification: ldapserver.PartialAttribute{AttrType: "cn", AttrVals: []string{"other"}}},
+	} {
+		code, err := s.modify("", ldapserver.ModifyRequest{DN: u1, Changes: []ldapserver.Change{change}}, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, code)
+	}
+
+	code, _ = s.modify("", ldapserver.ModifyRequest{DN: "cn=u3,ou=people,ou=test,dc=example,dc=com"}, nil)
+	assert.Equal(t, ldapserver.LDAPResultNoSuchObject, code)
+}
+
+func TestServer_delete(t *testing.T) {
+	s, b, file := newTestWriteServer(t)
+	defer os.Remove(file)
+
+	code, err := s.delete("", "cn=u2,ou=people,ou=test,dc=example,dc=com", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, ldapserver.LDAPResultSuccess, code)
+	users, _ := b.Users("", "")
+	assert.Equal(t, 1, len(users))
+	groups, _ := b.Groups("cn", "g1")
+	assert.Equal(t, []string{"u1"}, groups[0].Members)
+
+	code, _ = s.delete("", "cn=g2,ou=groups,ou=test,dc=example,dc=com", nil)
+	assert.Equal(t, ldapserver.LDAPResultSuccess, code)
+	code, _ = s.delete("", "cn=g2,ou=groups,ou=test,dc=example,dc=com", nil)
+	assert.Equal(t, ldapserver.LDAPResultNoSuchObject, code)
+}
+
+func TestServer_modifyDn(t *testing.T) {
+	s, b, file := newTestWriteServer(t)
+	defer os.Remove(file)
+
+	code, err := s.modifyDn("", ldapserver.ModifyDNRequest{DN: "cn=u2,ou=people,ou=test,dc=example,dc=com", NewRDN: "cn=u3", DeleteOldRDN: true}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, ldapserver.LDAPResultSuccess, code)
+	users, _ := b.Users("cn", "u3")
+	assert.Equal(t, 1, len(users))
+	assert.Equal(t, []string{"g1"}, users[0].Groups)
+	groups, _ := b.Groups("cn", "g1")
+	assert.ElementsMatch(t, []string{"u1", "u3"}, groups[0].Members)
+
+	for expected, req := range map[ldapserver.LDAPResultCode]ldapserver.ModifyDNRequest{
+		ldapserver.LDAPResultEntryAlreadyExists: {DN: "cn=u3,ou=people,ou=test,dc=example,dc=com", NewRDN: "cn=u1"},
+		ldapserver.LDAPResultNamingViolation:    {DN: "cn=u3,ou=people,ou=test,dc=example,dc=com", NewRDN: "uid=u4"},
+		ldapserver.LDAPResultUnwillingToPerform: {DN: "cn=u3,ou=people,ou=test,dc=example,dc=com", NewRDN: "cn=u4", NewSuperior: "ou=groups,ou=test,dc=example,dc=com"},
+		ldapserver.LDAPResultNoSuchObject:       {DN: "cn=u5,ou=people,ou=test,dc=example,dc=com", NewRDN: "cn=u6"},
+	} {
+		code, err := s.modifyDn("", req, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, code)
+	}
+}
+
+func TestApplyChanges(t *testing.T) {
+	attrs, err := applyChanges(map[string][]string{"Mail": {"a@example.org", "b@example.org"}}, []ldapserver.Change{
+		{Operation: ldapserver.DeleteAttribute, Modification: ldapserver.PartialAttribute{AttrType: "mail", AttrVals: []string{"A@example.org"}}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{"mail": {"b@example.org"}}, attrs)
+
+	_, err = applyChanges(map[string][]string{}, []ldapserver.Change{{Operation: 7}})
+	assert.Error(t, err)
+}
+
+func TestServer_modify_acl(t *testing.T) {
+	s, b, file := newTestWriteServer(t)
+	defer os.Remove(file)
+	s.config.acl, _ = loadTestACL(t, testACL)
+	s.config.writers = nil
+	s.sessions.bind(nil, "u2")
+	u2 := "cn=u2,ou=people,ou=test,dc=example,dc=com"
+
+	code, _ := s.modify("", ldapserver.ModifyRequest{DN: u2, Changes: []ldapserver.Change{
+		{Operation: ldapserver.ReplaceAttribute, Modification: ldapserver.PartialAttribute{AttrType: "Mail", AttrVals: []string{"u2@example.org"}}},
+	}}, nil)
+	assert.Equal(t, ldapserver.LDAPResultSuccess, code)
+	users, _ := b.Users("cn", "u2")
+	assert.Equal(t, []string{"u2@example.org"}, users[0].Attr["mail"])
+
+	code, _ = s.modify("", ldapserver.ModifyRequest{DN: u2, Changes: []ldapserver.Change{
+		{Operation: ldapserver.ReplaceAttribute, Modification: ldapserver.PartialAttribute{AttrType: "mail", AttrVals: []string{"u2@example.org"}}},
+		{Operation: ldapserver.ReplaceAttribute, Modification: ldapserver.PartialAttribute{AttrType: "uidNumber", AttrVals: []string{"0"}}},
+	}}, nil)
+	assert.Equal(t, ldapserver.LDAPResultInsufficientAccessRights, code)
+
+	code, _ = s.delete("", u2, nil)
+	assert.Equal(t, ldapserver.LDAPResultInsufficientAccessRights, code)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net"
+	"sync"
+)
+
+// sessions tracks the user or service account each connection is bound as.
+type sessions struct {
+	sync.RWMutex
+	bound map[net.Conn]identity
+}
+
+type identity struct {
+	name    string
+	service bool
+}
+
+func newSessions() *sessions {
+	return &sessions{bound: make(map[net.Conn]identity)}
+}
+
+func (s *sessions) bind(conn net.Conn, username string) {
+	s.Lock()
+	defer s.Unlock()
+	s.bound[conn] = identity{name: username}
+}
+
+func (s *sessions) bindService(conn net.Conn, name string) {
+	s.Lock()
+	defer s.Unlock()
+	s.bound[conn] = identity{name: name, service: true}
+}
+
+// user returns the user the connection is bound as, anonymous connections are not tracked.
+func (s *sessions) user(conn net.Conn) (string, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	id, ok := s.bound[conn]
+	return id.name, ok && !id.service
+}
+
+// service returns the service account the connection is bound as.
+func (s *sessions) service(conn net.Conn) (string, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	id, ok := s.bound[conn]
+	return id.name, ok && id.service
+}
+
+func (s *sessions) forget(conn net.Conn) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.bound, conn)
+}
+
+// sessionListener forgets the session of connections when they are closed.
+type sessionListener struct {
+	net.Listener
+	sessions *sessions
+}
+
+func (l *sessionListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &sessionConn{Conn: conn, sessions: l.sessions}, nil
+}
+
+type sessionConn struct {
+	net.Conn
+	sessions *sessions
+}
+
+func (c *sessionConn) Close() error {
+	c.sessions.forget(c)
+	return c.Conn.Close()
+}
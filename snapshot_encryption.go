@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+const (
+	snapshotKeySize = 32
+)
+
+var (
+	encryptedSnapshotMagic = []byte("ALDAPDE1")
+)
+
+// LoadSnapshotKey reads a 256 bit AES key, either raw or base64 encoded.
+// Create one with `head -c 32 /dev/urandom | base64 > snapshot.key`.
+func LoadSnapshotKey(file string) ([]byte, error) {
+	if content, err := ioutil.ReadFile(file); err != nil {
+		return nil, err
+	} else if key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(content))); err == nil && len(key) == snapshotKeySize {
+		return key, nil
+	} else if len(content) == snapshotKeySize {
+		return content, nil
+	} else {
+		return nil, fmt.Errorf("invalid snapshot key file %s, expected %d bytes", file, snapshotKeySize)
+	}
+}
+
+func isEncryptedSnapshot(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedSnapshotMagic)
+}
+
+// encryptSnapshot encrypts with AES-GCM, the result is laid out as magic || nonce || ciphertext.
+func encryptSnapshot(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newSnapshotCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append(append([]byte{}, encryptedSnapshotMagic...), nonce...)
+	return gcm.Seal(out, nonce, plaintext, encryptedSnapshotMagic), nil
+}
+
+func decryptSnapshot(key, data []byte) ([]byte, error) {
+	gcm, err := newSnapshotCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	data = data[len(encryptedSnapshotMagic):]
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("encrypted snapshot is truncated")
+	}
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], encryptedSnapshotMagic)
+}
+
+func newSnapshotCipher(key []byte) (cipher.AEAD, error) {
+	if block, err := aes.NewCipher(key); err != nil {
+		return nil, err
+	} else {
+		return cipher.NewGCM(block)
+	}
+}
+
+type encryptCommand struct {
+	KeyFile string `short:"k" long:"key-file" required:"true" description:"File with the snapshot key"`
+	Args    struct {
+		Input  string `positional-arg-name:"input" description:"Plaintext snapshot"`
+		Output string `positional-arg-name:"output" description:"Encrypted snapshot"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func (c *encryptCommand) Execute(args []string) error {
+	if key, err := LoadSnapshotKey(c.KeyFile); err != nil {
+		return err
+	} else if plaintext, err := ioutil.ReadFile(c.Args.Input); err != nil {
+		return err
+	} else if isEncryptedSnapshot(plaintext) {
+		return fmt.Errorf("%s is already encrypted", c.Args.Input)
+	} else if data, err := encryptSnapshot(key, plaintext); err != nil {
+		return err
+	} else {
+		return ioutil.WriteFile(c.Args.Output, data, 0600)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	testSnapshotKey = []byte("0123456789abcdef0123456789abcdef")
+)
+
+func TestLoadSnapshotKey(t *testing.T) {
+	f, _ := ioutil.TempFile(os.TempDir(), "aldapd-key")
+	defer os.Remove(f.Name())
+
+	f.WriteString(base64.StdEncoding.EncodeToString(testSnapshotKey) + "\n")
+	key, err := LoadSnapshotKey(f.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, testSnapshotKey, key)
+
+	ioutil.WriteFile(f.Name(), testSnapshotKey, 0600)
+	key, err = LoadSnapshotKey(f.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, testSnapshotKey, key)
+
+	ioutil.WriteFile(f.Name(), []byte("too short"), 0600)
+	_, err = LoadSnapshotKey(f.Name())
+	assert.Error(t, err)
+}
+
+func TestEncryptSnapshot(t *testing.T) {
+	data, err := encryptSnapshot(testSnapshotKey, []byte(validTestConfig))
+	assert.NoError(t, err)
+	assert.True(t, isEncryptedSnapshot(data))
+	assert.NotContains(t, string(data), "some-password")
+
+	plaintext, err := decryptSnapshot(testSnapshotKey, data)
+	assert.NoError(t, err)
+	assert.Equal(t, validTestConfig, string(plaintext))
+
+	_, err = decryptSnapshot([]byte("fedcba9876543210fedcba9876543210"), data)
+	assert.Error(t, err)
+
+	data[len(data)-1] ^= 1
+	_, err = decryptSnapshot(testSnapshotKey, data)
+	assert.Error(t, err)
+
+	_, err = decryptSnapshot(testSnapshotKey, encryptedSnapshotMagic)
+	assert.Error(t, err)
+}
+
+func TestEncryptCommand(t *testing.T) {
+	k, _ := ioutil.TempFile(os.TempDir(), "aldapd-key")
+	defer os.Remove(k.Name())
+	k.Write(testSnapshotKey)
+	in, _ := ioutil.TempFile(os.TempDir(), "aldapd-config")
+	defer os.Remove(in.Name())
+	in.WriteString(validTestConfig)
+	out := in.Name() + ".enc"
+	defer os.Remove(out)
+
+	c := &encryptCommand{KeyFile: k.Name()}
+	c.Args.Input = in.Name()
+	c.Args.Output = out
+	assert.NoError(t, c.Execute(nil))
+
+	_, err := NewLocalFileBackend([]string{out}, nil)
+	assert.Error(t, err)
+
+	b, err := NewLocalFileBackend([]string{out}, &SnapshotConfig{key: testSnapshotKey})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(b.usersByName))
+
+	c.Args.Input = out
+	assert.Error(t, c.Execute(nil))
+}
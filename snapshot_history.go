@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The snapshot history keeps the raw contents of the last loaded snapshots,
+// one numbered directory per generation holding a copy of every snapshot file and its signature.
+// Digests of snapshots rolled back from are listed in a file next to them and never loaded again.
+
+const rejectedFileName = "rejected"
+
+func (c *SnapshotConfig) generations() ([]int, error) {
+	entries, err := ioutil.ReadDir(c.historyDir)
+	if err != nil {
+		return nil, err
+	}
+	gens := make([]int, 0)
+	for _, e := range entries {
+		if gen, err := strconv.Atoi(e.Name()); err == nil && e.IsDir() {
+			gens = append(gens, gen)
+		}
+	}
+	sort.Ints(gens)
+	return gens, nil
+}
+
+func (c *SnapshotConfig) generationDir(gen int) string {
+	return filepath.Join(c.historyDir, fmt.Sprintf("%08d", gen))
+}
+
+func (c *SnapshotConfig) readGeneration(gen int) ([]string, [][]byte, error) {
+	dir := c.generationDir(gen)
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	files := make([]string, 0, len(entries))
+	contents := make([][]byte, 0, len(entries))
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), minisignSigSuffix) {
+			continue
+		}
+		file := filepath.Join(dir, e.Name())
+		if content, err := ioutil.ReadFile(file); err != nil {
+			return nil, nil, err
+		} else {
+			files = append(files, file)
+			contents = append(contents, content)
+		}
+	}
+	return files, contents, nil
+}
+
+// saveHistory adds the snapshot to the history, with the signatures it was verified with if given.
+func (c *SnapshotConfig) saveHistory(files []string, contents, sigs [][]byte) error {
+	if err := os.MkdirAll(c.historyDir, 0700); err != nil {
+		return err
+	}
+	gens, err := c.generations()
+	if err != nil {
+		return err
+	}
+
+	next := 1
+	if len(gens) > 0 {
+		latest := gens[len(gens)-1]
+		if _, latestContents, err := c.readGeneration(latest); err == nil && equalContents(latestContents, contents) {
+			log.Debugf("snapshot unchanged, not adding it to history")
+			return nil
+		}
+		next = latest + 1
+	}
+
+	dir := c.generationDir(next)
+	if err := os.Mkdir(dir, 0700); err != nil {
+		return err
+	}
+	for i, f := range files {
+		// prefix with the index, as snapshot files are read in order
+		name := fmt.Sprintf("%03d-%s", i, filepath.Base(f))
+		if err := ioutil.WriteFile(filepath.Join(dir, name), contents[i], 0600); err != nil {
+			return err
+		}
+		// signatures are kept to verify the generation again before rolling back to it
+		if sigs != nil && sigs[i] != nil {
+			if err := ioutil.WriteFile(filepath.Join(dir, name+minisignSigSuffix), sigs[i], 0600); err != nil {
+				return err
+			}
+		}
+	}
+	log.Infof("saved snapshot to history as generation %d", next)
+
+	gens = append(gens, next)
+	for len(gens) > c.historySize && c.historySize > 0 {
+		log.Debugf("removing generation %d from snapshot history", gens[0])
+		if err := os.RemoveAll(c.generationDir(gens[0])); err != nil {
+			return err
+		}
+		gens = gens[1:]
+	}
+	return nil
+}
+
+func (c *SnapshotConfig) previousFromHistory() ([]string, [][]byte, error) {
+	if c.historyDir == "" {
+		return nil, nil, fmt.Errorf("snapshot history is disabled")
+	} else if gens, err := c.generations(); err != nil {
+		return nil, nil, err
+	} else if len(gens) < 2 {
+		return nil, nil, fmt.Errorf("no previous snapshot in history")
+	} else {
+		log.Infof("rolling back to snapshot generation %d", gens[len(gens)-2])
+		return c.readGeneration(gens[len(gens)-2])
+	}
+}
+
+func (c *SnapshotConfig) latestFromHistory() ([]string, [][]byte, error) {
+	if gens, err := c.generations(); err != nil {
+		return nil, nil, err
+	} else if len(gens) == 0 {
+		return nil, nil, fmt.Errorf("no snapshot in history")
+	} else {
+		log.Infof("loading snapshot generation %d", gens[len(gens)-1])
+		return c.readGeneration(gens[len(gens)-1])
+	}
+}
+
+// rejectLatestFromHistory removes the latest generation after rolling back from it and records its digest.
+func (c *SnapshotConfig) rejectLatestFromHistory() error {
+	gens, err := c.generations()
+	if err != nil || len(gens) == 0 {
+		return err
+	}
+	latest := c.generationDir(gens[len(gens)-1])
+	if _, contents, err := c.readGeneration(gens[len(gens)-1]); err != nil {
+		return err
+	} else if f, err := os.OpenFile(c.rejectedFile(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600); err != nil {
+		return err
+	} else if _, err := fmt.Fprintln(f, snapshotDigest(contents)); err != nil {
+		f.Close()
+		return err
+	} else if err := f.Close(); err != nil {
+		return err
+	}
+	return os.RemoveAll(latest)
+}
+
+func (c *SnapshotConfig) rejectedFile() string {
+	return filepath.Join(c.historyDir, rejectedFileName)
+}
+
+// rejected reports whether the snapshot was rolled back from.
+func (c *SnapshotConfig) rejected(contents [][]byte) bool {
+	content, err := ioutil.ReadFile(c.rejectedFile())
+	if err != nil {
+		return false
+	}
+	digest := snapshotDigest(contents)
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.TrimSpace(line) == digest {
+			return true
+		}
+	}
+	return false
+}
+
+// snapshotDigest hashes the contents of all snapshot files.
+func snapshotDigest(contents [][]byte) string {
+	h := sha256.New()
+	for _, content := range contents {
+		fmt.Fprintf(h, "%d:", len(content))
+		h.Write(content)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func equalContents(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
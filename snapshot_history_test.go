@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testSnapshotWithVersion(version int, users ...string) string {
+	s := fmt.Sprintf(`{"version":%d, "generated_at":"2026-01-0%dT10:00:00Z", "users":[`, version, version)
+	for i, u := range users {
+		if i > 0 {
+			s += ","
+		}
+		s += fmt.Sprintf(`{"name":%q}`, u)
+	}
+	return s + "]}"
+}
+
+func TestLocalFileBackend_Reload_version(t *testing.T) {
+	f, _ := ioutil.TempFile(os.TempDir(), "aldapd-config")
+	defer os.Remove(f.Name())
+	ioutil.WriteFile(f.Name(), []byte(testSnapshotWithVersion(2, "u1")), 0600)
+
+	b, err := NewLocalFileBackend([]string{f.Name()}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), b.version)
+	assert.Equal(t, 2, b.generatedAt.Day())
+
+	ioutil.WriteFile(f.Name(), []byte(testSnapshotWithVersion(1, "u1", "u2")), 0600)
+	assert.Error(t, b.Reload())
+	assert.Equal(t, int64(2), b.version)
+	assert.Equal(t, 1, len(b.usersByName))
+
+	b.snapshot.allowDowngrade = true
+	assert.NoError(t, b.Reload())
+	assert.Equal(t, int64(1), b.version)
+	assert.Equal(t, 2, len(b.usersByName))
+}
+
+func TestLocalFileBackend_Rollback(t *testing.T) {
+	dir, _ := ioutil.TempDir(os.TempDir(), "aldapd-history")
+	defer os.RemoveAll(dir)
+	f, _ := ioutil.TempFile(os.TempDir(), "aldapd-config")
+	defer os.Remove(f.Name())
+	ioutil.WriteFile(f.Name(), []byte(testSnapshotWithVersion(1, "u1")), 0600)
+
+	b, err := NewLocalFileBackend([]string{f.Name()}, &SnapshotConfig{historyDir: dir, historySize: 2})
+	assert.NoError(t, err)
+	assert.Error(t, b.Rollback())
+
+	// unchanged snapshots are not added to history
+	assert.NoError(t, b.Reload())
+	gens, _ := b.snapshot.generations()
+	assert.Equal(t, []int{1}, gens)
+
+	for v := 2; v <= 3; v++ {
+		ioutil.WriteFile(f.Name(), []byte(testSnapshotWithVersion(v, "u1", fmt.Sprintf("u%d", v))), 0600)
+		assert.NoError(t, b.Reload())
+	}
+	gens, _ = b.snapshot.generations()
+	assert.Equal(t, []int{2, 3}, gens)
+	assert.Equal(t, int64(3), b.version)
+
+	assert.NoError(t, b.Rollback())
+	assert.Equal(t, int64(2), b.version)
+	assert.NotNil(t, b.usersByName["u2"])
+	assert.Nil(t, b.usersByName["u3"])
+	gens, _ = b.snapshot.generations()
+	assert.Equal(t, []int{2}, gens)
+
+	assert.Error(t, b.Rollback())
+	assert.Equal(t, int64(2), b.version)
+
+	// the snapshot rolled back from isn't loaded again, not even after a restart
+	assert.NoError(t, b.Reload())
+	assert.Equal(t, int64(2), b.version)
+	b, err = NewLocalFileBackend([]string{f.Name()}, &SnapshotConfig{historyDir: dir, historySize: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), b.version)
+	assert.Nil(t, b.usersByName["u3"])
+
+	// until a new snapshot is published
+	ioutil.WriteFile(f.Name(), []byte(testSnapshotWithVersion(4, "u1")), 0600)
+	assert.NoError(t, b.Reload())
+	assert.Equal(t, int64(4), b.version)
+}
+
+func TestLocalFileBackend_Rollback_disabled(t *testing.T) {
+	b := &localFileBackend{snapshot: &SnapshotConfig{}}
+	assert.Error(t, b.Rollback())
+}
+
+func TestLocalFileBackend_Rollback_signed(t *testing.T) {
+	s := newTestSigner()
+	k, _ := parseMinisignPublicKey(strings.Split(s.publicKey(), "\n")[1])
+	dir, _ := ioutil.TempDir(os.TempDir(), "aldapd-history")
+	defer os.RemoveAll(dir)
+	f, _ := ioutil.TempFile(os.TempDir(), "aldapd-config")
+	defer os.Remove(f.Name())
+	defer os.Remove(f.Name() + minisignSigSuffix)
+	write := func(content string) {
+		ioutil.WriteFile(f.Name(), []byte(content), 0600)
+		ioutil.WriteFile(f.Name()+minisignSigSuffix, []byte(s.sign(minisignAlgPrehashed, []byte(content), "foo")), 0600)
+	}
+
+	write(testSnapshotWithVersion(1, "u1"))
+	b, err := NewLocalFileBackend([]string{f.Name()}, &SnapshotConfig{publicKey: k, historyDir: dir, historySize: 3})
+	assert.NoError(t, err)
+	write(testSnapshotWithVersion(2, "u1", "u2"))
+	assert.NoError(t, b.Reload())
+	// the verified signature is kept with the snapshot
+	sig, _ := ioutil.ReadFile(f.Name() + minisignSigSuffix)
+	files, _, _ := b.snapshot.readGeneration(2)
+	saved, _ := ioutil.ReadFile(files[0] + minisignSigSuffix)
+	assert.Equal(t, sig, saved)
+
+	// tampered history generations are refused
+	files, _, _ = b.snapshot.readGeneration(1)
+	assert.Equal(t, 1, len(files))
+	original, _ := ioutil.ReadFile(files[0])
+	ioutil.WriteFile(files[0], []byte(testSnapshotWithVersion(1, "evil")), 0600)
+	assert.Error(t, b.Rollback())
+	assert.Equal(t, int64(2), b.version)
+
+	ioutil.WriteFile(files[0], original, 0600)
+	assert.NoError(t, b.Rollback())
+	assert.Equal(t, int64(1), b.version)
+	assert.Nil(t, b.usersByName["u2"])
+}
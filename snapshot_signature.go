@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+const (
+	minisignAlgLegacy    = "Ed"
+	minisignAlgPrehashed = "ED"
+	minisignSigSuffix    = ".minisig"
+	trustedCommentPrefix = "trusted comment: "
+)
+
+type minisignPublicKey struct {
+	keyId [8]byte
+	key   ed25519.PublicKey
+}
+
+// LoadMinisignPublicKey reads a public key as written by `minisign -G`.
+// A file with just the base64 encoded key is accepted as well.
+func LoadMinisignPublicKey(file string) (*minisignPublicKey, error) {
+	if content, err := ioutil.ReadFile(file); err != nil {
+		return nil, err
+	} else {
+		lines := nonCommentLines(content)
+		if len(lines) != 1 {
+			return nil, fmt.Errorf("invalid public key file %s", file)
+		}
+		return parseMinisignPublicKey(lines[0])
+	}
+}
+
+func parseMinisignPublicKey(s string) (*minisignPublicKey, error) {
+	byts, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	} else if len(byts) != 2+8+ed25519.PublicKeySize || string(byts[:2]) != minisignAlgLegacy {
+		return nil, fmt.Errorf("unsupported public key format")
+	}
+	k := &minisignPublicKey{key: ed25519.PublicKey(byts[10:])}
+	copy(k.keyId[:], byts[2:10])
+	return k, nil
+}
+
+// verifyFile checks data against the detached signature stored next to the file.
+func (k *minisignPublicKey) verifyFile(file string, data []byte) error {
+	if sig, err := ioutil.ReadFile(file + minisignSigSuffix); err != nil {
+		return fmt.Errorf("error reading signature for %s: %s", file, err.Error())
+	} else if err := k.verify(data, sig); err != nil {
+		return fmt.Errorf("invalid signature for %s: %s", file, err.Error())
+	} else {
+		return nil
+	}
+}
+
+func (k *minisignPublicKey) verify(data, sigFile []byte) error {
+	lines := strings.Split(strings.TrimSpace(string(sigFile)), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[2], trustedCommentPrefix) {
+		return fmt.Errorf("malformed signature file")
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil {
+		return err
+	} else if len(sig) != 2+8+ed25519.SignatureSize {
+		return fmt.Errorf("malformed signature")
+	} else if !bytes.Equal(sig[2:10], k.keyId[:]) {
+		return fmt.Errorf("signed with unknown key")
+	}
+
+	switch string(sig[:2]) {
+	case minisignAlgLegacy:
+	case minisignAlgPrehashed:
+		h := blake2b.Sum512(data)
+		data = h[:]
+	default:
+		return fmt.Errorf("unsupported signature algorithm")
+	}
+	if !ed25519.Verify(k.key, data, sig[10:]) {
+		return fmt.Errorf("signature verification failed")
+	}
+
+	globalSig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil {
+		return err
+	}
+	trustedComment := strings.TrimSuffix(lines[2][len(trustedCommentPrefix):], "\r")
+	if !ed25519.Verify(k.key, append(append([]byte{}, sig[10:]...), trustedComment...), globalSig) {
+		return fmt.Errorf("trusted comment verification failed")
+	}
+	return nil
+}
+
+func nonCommentLines(content []byte) []string {
+	lines := make([]string, 0)
+	for _, l := range strings.Split(string(content), "\n") {
+		if l = strings.TrimSpace(l); l != "" && !strings.HasPrefix(l, "untrusted comment:") {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
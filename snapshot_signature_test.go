@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/blake2b"
+)
+
+type testSigner struct {
+	keyId []byte
+	key   ed25519.PrivateKey
+	pub   ed25519.PublicKey
+}
+
+func newTestSigner() *testSigner {
+	pub, key, _ := ed25519.GenerateKey(rand.Reader)
+	return &testSigner{keyId: []byte("12345678"), key: key, pub: pub}
+}
+
+func (s *testSigner) publicKey() string {
+	pk := append([]byte(minisignAlgLegacy), s.keyId...)
+	return fmt.Sprintf("untrusted comment: minisign public key\n%s\n",
+		base64.StdEncoding.EncodeToString(append(pk, s.pub...)))
+}
+
+func (s *testSigner) sign(alg string, data []byte, trustedComment string) string {
+	if alg == minisignAlgPrehashed {
+		h := blake2b.Sum512(data)
+		data = h[:]
+	}
+	sig := ed25519.Sign(s.key, data)
+	globalSig := ed25519.Sign(s.key, append(append([]byte{}, sig...), trustedComment...))
+	return fmt.Sprintf("untrusted comment: signature\n%s\ntrusted comment: %s\n%s\n",
+		base64.StdEncoding.EncodeToString(append(append([]byte(alg), s.keyId...), sig...)),
+		trustedComment,
+		base64.StdEncoding.EncodeToString(globalSig))
+}
+
+func TestLoadMinisignPublicKey(t *testing.T) {
+	s := newTestSigner()
+	f, _ := ioutil.TempFile(os.TempDir(), "aldapd-pub")
+	defer os.Remove(f.Name())
+	f.WriteString(s.publicKey())
+
+	k, err := LoadMinisignPublicKey(f.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, "12345678", string(k.keyId[:]))
+	assert.Equal(t, s.pub, k.key)
+}
+
+func TestLoadMinisignPublicKey_invalid(t *testing.T) {
+	f, _ := ioutil.TempFile(os.TempDir(), "aldapd-pub")
+	defer os.Remove(f.Name())
+	f.WriteString("not a key")
+
+	_, err := LoadMinisignPublicKey(f.Name())
+	assert.Error(t, err)
+}
+
+func TestMinisignPublicKey_verify(t *testing.T) {
+	s := newTestSigner()
+	k, _ := parseMinisignPublicKey(strings.Split(s.publicKey(), "\n")[1])
+	data := []byte(validTestConfig)
+
+	for _, alg := range []string{minisignAlgLegacy, minisignAlgPrehashed} {
+		sig := s.sign(alg, data, "timestamp:1234")
+		assert.NoError(t, k.verify(data, []byte(sig)), "for %s", alg)
+		assert.Error(t, k.verify([]byte("tampered"), []byte(sig)), "for %s", alg)
+		assert.Error(t, k.verify(data, []byte(strings.Replace(sig, "1234", "4321", 1))), "for %s", alg)
+	}
+
+	other := newTestSigner()
+	assert.Error(t, k.verify(data, []byte(other.sign(minisignAlgLegacy, data, "foo"))))
+	assert.Error(t, k.verify(data, []byte("garbage")))
+}
+
+func TestNewLocalFileBackend_signed(t *testing.T) {
+	s := newTestSigner()
+	k, _ := parseMinisignPublicKey(strings.Split(s.publicKey(), "\n")[1])
+	snapshot := &SnapshotConfig{publicKey: k}
+
+	f, _ := ioutil.TempFile(os.TempDir(), "aldapd-config")
+	defer os.Remove(f.Name())
+	f.WriteString(validTestConfig)
+
+	_, err := NewLocalFileBackend([]string{f.Name()}, snapshot)
+	assert.Error(t, err)
+
+	ioutil.WriteFile(f.Name()+minisignSigSuffix, []byte(s.sign(minisignAlgPrehashed, []byte(validTestConfig), "foo")), 0600)
+	defer os.Remove(f.Name() + minisignSigSuffix)
+	b, err := NewLocalFileBackend([]string{f.Name()}, snapshot)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(b.usersByName))
+
+	f.WriteString(`,"users":[{"name":"evil"}]}`)
+	assert.Error(t, b.Reload())
+	assert.Equal(t, 2, len(b.usersByName))
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"regexp"
+	"sort"
+)
+
+var (
+	templatePlaceholder = regexp.MustCompile(`\{(\w+)\}`)
+)
+
+// AttrTemplates compute attributes of users and groups not setting them explicitly.
+// Placeholders like {mail} are replaced by the first value of the attribute, {name} by the user's or group's name.
+type AttrTemplates struct {
+	Users  map[string][]string `json:"users"`
+	Groups map[string][]string `json:"groups"`
+}
+
+func LoadAttrTemplates(file string) (*AttrTemplates, error) {
+	var templates AttrTemplates
+	if content, err := ioutil.ReadFile(file); err != nil {
+		return nil, err
+	} else if err := json.Unmarshal(content, &templates); err != nil {
+		return nil, err
+	}
+	return &templates, nil
+}
+
+// mergeTemplates returns templates of base overridden by templates of override per attribute.
+func mergeTemplates(base, override *AttrTemplates) *AttrTemplates {
+	merged := &AttrTemplates{Users: map[string][]string{}, Groups: map[string][]string{}}
+	for _, t := range []*AttrTemplates{base, override} {
+		if t == nil {
+			continue
+		}
+		for k, v := range t.Users {
+			merged.Users[k] = v
+		}
+		for k, v := range t.Groups {
+			merged.Groups[k] = v
+		}
+	}
+	return merged
+}
+
+func (t *AttrTemplates) apply(usersByName map[string]*User, groupsByName map[string]*Group) {
+	for _, u := range usersByName {
+		u.Attr = applyTemplates(t.Users, u.Name, u.Attr)
+	}
+	for _, g := range groupsByName {
+		g.Attr = applyTemplates(t.Groups, g.Name, g.Attr)
+	}
+}
+
+// applyTemplates adds all attributes computable from the templates and not set already.
+// Templates may refer to attributes computed by other templates.
+func applyTemplates(templates map[string][]string, name string, attr map[string][]string) map[string][]string {
+	if len(templates) == 0 {
+		return attr
+	}
+	if attr == nil {
+		attr = make(map[string][]string)
+	}
+
+	pending := make([]string, 0)
+	for k := range templates {
+		if _, ok := attr[k]; !ok {
+			pending = append(pending, k)
+		}
+	}
+	sort.Strings(pending)
+
+	for progress := true; progress && len(pending) > 0; {
+		progress = false
+		remaining := make([]string, 0)
+		for _, k := range pending {
+			if values, ok := renderTemplates(templates[k], name, attr); ok {
+				attr[k] = values
+				progress = true
+			} else {
+				remaining = append(remaining, k)
+			}
+		}
+		pending = remaining
+	}
+	if len(pending) > 0 {
+		log.Debugf("not computing attributes %v of %s, missing values", pending, name)
+	}
+	return attr
+}
+
+func renderTemplates(templates []string, name string, attr map[string][]string) ([]string, bool) {
+	values := make([]string, len(templates))
+	for i, t := range templates {
+		ok := true
+		values[i] = templatePlaceholder.ReplaceAllStringFunc(t, func(p string) string {
+			key := p[1 : len(p)-1]
+			if key == "name" {
+				return name
+			} else if v, found := attr[key]; found && len(v) > 0 {
+				return v[0]
+			} else {
+				ok = false
+				return p
+			}
+		})
+		if !ok {
+			return nil, false
+		}
+	}
+	return values, true
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyTemplates(t *testing.T) {
+	templates := map[string][]string{
+		"mail":        {"{name}@example.org"},
+		"displayName": {"{givenName} {sn}"},
+		"gecos":       {"{displayName},{mail}"},
+		"description": {"{missing}"},
+	}
+
+	attr := applyTemplates(templates, "u1", map[string][]string{
+		"givenName": {"Jason"},
+		"sn":        {"Doe"},
+	})
+	assert.Equal(t, map[string][]string{
+		"givenName":   {"Jason"},
+		"sn":          {"Doe"},
+		"mail":        {"u1@example.org"},
+		"displayName": {"Jason Doe"},
+		"gecos":       {"Jason Doe,u1@example.org"},
+	}, attr)
+
+	attr = applyTemplates(templates, "u2", map[string][]string{
+		"mail": {"other@example.org"},
+	})
+	assert.Equal(t, map[string][]string{
+		"mail": {"other@example.org"},
+	}, attr)
+
+	assert.Nil(t, applyTemplates(map[string][]string{}, "u3", nil))
+}
+
+func TestMergeTemplates(t *testing.T) {
+	merged := mergeTemplates(&AttrTemplates{
+		Users:  map[string][]string{"mail": {"{name}@example.org"}, "loginShell": {"/bin/sh"}},
+		Groups: map[string][]string{"description": {"{name}"}},
+	}, &AttrTemplates{
+		Users: map[string][]string{"mail": {"{name}@example.com"}},
+	})
+	assert.Equal(t, map[string][]string{"mail": {"{name}@example.com"}, "loginShell": {"/bin/sh"}}, merged.Users)
+	assert.Equal(t, map[string][]string{"description": {"{name}"}}, merged.Groups)
+
+	assert.Equal(t, 0, len(mergeTemplates(nil, nil).Users))
+}
+
+func TestNewLocalFileBackend_templates(t *testing.T) {
+	f, _ := ioutil.TempFile(os.TempDir(), "aldapd-config")
+	defer os.Remove(f.Name())
+	f.WriteString(`{
+	"templates": {
+		"users": {"mail": ["{name}@example.org"]},
+		"groups": {"description": ["group {name}"]}
+	},
+	"users": [{"name":"u1"}, {"name":"u2", "attr":{"mail":["u2@example.com"]}}],
+	"groups": [{"name":"g1"}]
+}`)
+
+	b, err := NewLocalFileBackend([]string{f.Name()}, &SnapshotConfig{templates: &AttrTemplates{
+		Users: map[string][]string{"homeDirectory": {"/home/{name}"}, "mail": {"{name}@example.net"}},
+	}})
+	assert.NoError(t, err)
+
+	users, err := b.Users("mail", "u1@example.org")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(users))
+	assert.Equal(t, []string{"/home/u1"}, users[0].Attr["homeDirectory"])
+	assert.Equal(t, []string{"u2@example.com"}, b.usersByName["u2"].Attr["mail"])
+	assert.Equal(t, []string{"group g1"}, b.groupsByName["g1"].Attr["description"])
+}
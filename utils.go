@@ -2,8 +2,12 @@ package main
 
 import (
 	"fmt"
-	"regexp"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
 	"sort"
+	"strings"
 
 	"github.com/op/go-logging"
 )
@@ -28,26 +32,68 @@ func appendIfMissing(arr []string, s string) []string {
 	return r
 }
 
-func cn2dn(baseDn, cn string) string {
-	return fmt.Sprintf("cn=%s,%s", cn, baseDn)
+func name2dn(attr, baseDn, name string) string {
+	return fmt.Sprintf("%s,%s", AttributeTypeAndValue{Type: attr, Value: name}, baseDn)
 }
 
-func cns2dns(baseDn string, cns []string) []string {
-	dns := make([]string, len(cns))
-	for i, cn := range cns {
-		dns[i] = cn2dn(baseDn, cn)
+func names2dns(attr, baseDn string, names []string) []string {
+	dns := make([]string, len(names))
+	for i, name := range names {
+		dns[i] = name2dn(attr, baseDn, name)
 	}
 	return dns
 }
 
-func dn2cn(baseDn, dn string) (string, bool) {
-	re := regexp.MustCompile(fmt.Sprintf("^cn=([^,]+),.*%s$", baseDn))
-	found := re.FindStringSubmatch(dn)
-	if len(found) < 2 {
-		log.Warningf("failed to convert dn=%s to username, baseDn=%s", dn, baseDn)
+// dn2name returns the value of the naming attribute of a DN anywhere below the base DN.
+func dn2name(attr, baseDn, dn string) (string, bool) {
+	if d, err := ParseDN(dn); err != nil {
+		log.Warningf("failed to convert dn=%s to name: %s", dn, err.Error())
 		return "", false
+	} else if base, err := ParseDN(baseDn); err != nil {
+		log.Warningf("failed to convert dn=%s to name: %s", dn, err.Error())
+		return "", false
+	} else if !d.IsDescendantOf(base) {
+		log.Warningf("failed to convert dn=%s to name, not below baseDn=%s", dn, baseDn)
+		return "", false
+	} else if t, v, ok := d[0].Single(); !ok || !strings.EqualFold(t, attr) {
+		log.Warningf("failed to convert dn=%s to name, attr=%s", dn, attr)
+		return "", false
+	} else {
+		return v, true
+	}
+}
+
+// childName returns the value of the naming attribute of a DN directly below the parent DN.
+func childName(dn, attr, parentDn string) (string, bool) {
+	if d, err := ParseDN(dn); err != nil || len(d) < 2 || !equalDns(d[1:].String(), parentDn) {
+		return "", false
+	} else if t, v, ok := d[0].Single(); !ok || !strings.EqualFold(t, attr) {
+		return "", false
+	} else {
+		return v, true
 	}
-	return found[1], true
+}
+
+// writeFileAtomically replaces the file by renaming a temporary file next to it, keeping its permissions.
+func writeFileAtomically(file string, content []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if info, err := os.Stat(file); err == nil {
+		tmp.Chmod(info.Mode())
+	}
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	} else if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	} else if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
 }
 
 func redactNonEmpty(s string) string {
@@ -57,3 +103,15 @@ func redactNonEmpty(s string) string {
 		return ""
 	}
 }
+
+// remoteIP returns the source IP of the connection, empty for unknown sources.
+func remoteIP(conn net.Conn) string {
+	if conn == nil || conn.RemoteAddr() == nil {
+		return ""
+	}
+	addr := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}